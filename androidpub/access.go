@@ -5,18 +5,36 @@ package androidpub
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	ap "google.golang.org/api/androidpublisher/v3"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 )
 
+// editCleanupTimeout is how long deleting an abandoned edit can take.
+const editCleanupTimeout = 30 * time.Second
+
 // GetAPService reads the service credentials from the JSON file and creates
-// a new Android Publisher service with them.
-func GetAPService(credentialsJson string) (*ap.Service, error) {
-	ctx := context.Background()
-	option := option.WithCredentialsFile(credentialsJson)
-	service, err := ap.NewService(ctx, option)
+// a new Android Publisher service with them.  If credentialsJson is "" no
+// credentials are read, which is for use with opts that supply their own,
+// like the fakeplay server ClientOptions.
+func GetAPService(
+	ctx context.Context,
+	credentialsJson string, opts ...option.ClientOption) (*ap.Service, error) {
+
+	var all []option.ClientOption
+	if credentialsJson != "" {
+		all = append(all, option.WithCredentialsFile(credentialsJson))
+	}
+	all = append(all, opts...)
+	service, err := ap.NewService(ctx, all...)
 	if err != nil {
 		return nil, fmt.Errorf("creating new service %s got %v", credentialsJson, err)
 	}
@@ -24,8 +42,10 @@ func GetAPService(credentialsJson string) (*ap.Service, error) {
 }
 
 // EditsInsert gets an edit ID for the given package.
-func EditsInsert(service *ap.Service, packageName string) (string, error) {
-	appEdit, err := EditsInsertAppEdit(service, packageName)
+func EditsInsert(
+	ctx context.Context, pub Publisher, packageName string) (string, error) {
+
+	appEdit, err := EditsInsertAppEdit(ctx, pub, packageName)
 	if err != nil {
 		return "", fmt.Errorf("inserting edit for %s got %v", packageName, err)
 	}
@@ -33,19 +53,196 @@ func EditsInsert(service *ap.Service, packageName string) (string, error) {
 }
 
 // EditsInsertAppEdit returns the full Android Publisher AppEdit
-func EditsInsertAppEdit(service *ap.Service, packageName string) (*ap.AppEdit, error) {
-	appEdit, err := service.Edits.Insert(packageName, nil).Do()
+func EditsInsertAppEdit(
+	ctx context.Context,
+	pub Publisher, packageName string) (*ap.AppEdit, error) {
+
+	appEdit, err := pub.InsertEdit(ctx, packageName)
 	if err != nil {
 		return nil, fmt.Errorf("inserting edit for %s got %v", packageName, err)
 	}
 	return appEdit, nil
 }
 
-// EditsCommit commits the pending edit for the package.
-func EditsCommit(service *ap.Service, packageName string, editId string) error {
-	_, err := service.Edits.Commit(packageName, editId).Do()
+// EditsValidate checks the pending edit for the package could be committed.
+func EditsValidate(
+	ctx context.Context, pub Publisher, packageName string, editId string) error {
+
+	_, err := pub.ValidateEdit(ctx, packageName, editId)
+	if err != nil {
+		return fmt.Errorf("validating edit for %s got %v", packageName, err)
+	}
+	return nil
+}
+
+// EditsCommit commits the pending edit for the package.  If
+// notSentForReview is set the changes are not sent for review, they have to
+// be sent from the Play Console.  Apps with managed publishing need this.
+func EditsCommit(
+	ctx context.Context, pub Publisher, packageName string, editId string,
+	notSentForReview bool) error {
+
+	_, err := pub.CommitEdit(ctx, packageName, editId, notSentForReview)
+	switch {
+	case err == nil:
+		return nil
+	case isReviewError(err) && !notSentForReview:
+		return fmt.Errorf("commiting edit for %s got %v\n"+
+			"with managed publishing changes must be committed as not sent "+
+			"for review and then sent from the Play Console",
+			packageName, err)
+	case isReviewError(err):
+		return fmt.Errorf("commiting edit for %s got %v\n"+
+			"without managed publishing changes are sent for review "+
+			"automatically and can't be committed as not sent for review",
+			packageName, err)
+	}
+	return fmt.Errorf("commiting edit for %s got %v", packageName, err)
+}
+
+// isReviewError is true if err is the API saying changesNotSentForReview
+// must, or must not, be set.
+func isReviewError(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) &&
+		apiErr.Code == http.StatusBadRequest &&
+		strings.Contains(apiErr.Message, "changesNotSentForReview")
+}
+
+// EditsDelete deletes the pending edit for the package discarding any
+// changes made in it.
+func EditsDelete(
+	ctx context.Context, pub Publisher, packageName string, editId string) error {
+
+	err := pub.DeleteEdit(ctx, packageName, editId)
 	if err != nil {
-		return fmt.Errorf("commiting edit for %s got %v", packageName, err)
+		return fmt.Errorf("deleting edit for %s got %v", packageName, err)
 	}
 	return nil
 }
+
+// EditSession is an edit that is deleted, when closed, unless it was
+// committed, deleted or kept.  That way an error, or a cancel, part way
+// through changing an edit doesn't leave it open to conflict with the next
+// edit.  Use it like:
+//
+//	session, err := NewEditSession(ctx, pub, packageName)
+//	if err != nil {
+//		return err
+//	}
+//	defer session.Close()
+//	// Change session.Id.
+//	return session.Commit(ctx, false)
+type EditSession struct {
+	PackageName string
+	Id          string
+	// Expires is when the Play Store drops the edit, zero if not known.
+	Expires time.Time
+
+	ctx  context.Context
+	pub  Publisher
+	done bool // Committed, deleted or kept.
+}
+
+// NewEditSession inserts a new edit for the package.
+func NewEditSession(
+	ctx context.Context,
+	pub Publisher, packageName string) (*EditSession, error) {
+
+	appEdit, err := EditsInsertAppEdit(ctx, pub, packageName)
+	if err != nil {
+		return nil, err
+	}
+	return newEditSession(ctx, pub, packageName, appEdit), nil
+}
+
+// ResumeEditSession returns a session for an open edit, such as one made
+// by PackageEdit.  The edit belongs to whoever opened it so it is kept, not
+// deleted, when the session is closed.
+func ResumeEditSession(
+	ctx context.Context,
+	pub Publisher, packageName, editId string) (*EditSession, error) {
+
+	appEdit, err := pub.GetEdit(ctx, packageName, editId)
+	if err != nil {
+		return nil, fmt.Errorf("getting %s edit %s got %v",
+			packageName, editId, err)
+	}
+	session := newEditSession(ctx, pub, packageName, appEdit)
+	if session.Expired() {
+		return nil, fmt.Errorf("%s edit %s expired at %v",
+			packageName, editId, session.Expires)
+	}
+	session.Keep()
+	return session, nil
+}
+
+func newEditSession(
+	ctx context.Context, pub Publisher, packageName string,
+	appEdit *ap.AppEdit) *EditSession {
+
+	session := &EditSession{
+		PackageName: packageName,
+		Id:          appEdit.Id,
+		ctx:         ctx,
+		pub:         pub,
+	}
+	seconds, err := strconv.ParseInt(appEdit.ExpiryTimeSeconds, 10, 64)
+	if err == nil {
+		session.Expires = time.Unix(seconds, 0)
+	}
+	return session
+}
+
+// Expired is true if the edit has expired.
+func (s *EditSession) Expired() bool {
+	return !s.Expires.IsZero() && time.Now().After(s.Expires)
+}
+
+// Commit validates and commits the edit.  See EditsCommit for
+// notSentForReview.  If it fails the edit is still deleted on Close.
+func (s *EditSession) Commit(ctx context.Context, notSentForReview bool) error {
+	if s.Expired() {
+		return fmt.Errorf("%s edit %s expired at %v",
+			s.PackageName, s.Id, s.Expires)
+	}
+	if err := EditsValidate(ctx, s.pub, s.PackageName, s.Id); err != nil {
+		return err
+	}
+	err := EditsCommit(ctx, s.pub, s.PackageName, s.Id, notSentForReview)
+	if err != nil {
+		return err
+	}
+	s.done = true
+	return nil
+}
+
+// Delete deletes the edit discarding its changes.
+func (s *EditSession) Delete(ctx context.Context) error {
+	s.done = true
+	return EditsDelete(ctx, s.pub, s.PackageName, s.Id)
+}
+
+// Keep leaves the edit open when the session is closed.
+func (s *EditSession) Keep() {
+	s.done = true
+}
+
+// Close deletes the edit if it wasn't committed, deleted or kept.  The
+// delete uses its own context as the session one may be cancelled.
+func (s *EditSession) Close() {
+	if s.done {
+		return
+	}
+	s.done = true
+	cleanup, cancel := context.WithTimeout(
+		context.Background(), editCleanupTimeout)
+	defer cancel()
+	if err := EditsDelete(cleanup, s.pub, s.PackageName, s.Id); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+		return
+	}
+	if err := s.ctx.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "deleted edit %s after %v\n", s.Id, err)
+	}
+}
@@ -0,0 +1,101 @@
+// details.go
+// Contains functions for changing the app details, the default language
+// and the contact email, website and phone.
+package androidpub
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	ap "google.golang.org/api/androidpublisher/v3"
+)
+
+// PackageSetDetails changes the app details.  The non empty fields of
+// details are changed, the others are left as they are.  A new default
+// language must already have a listing, it is checked before the details
+// are changed.  Only the DryRun, NotSentForReview, EditId and Progress
+// fields of opts are used.
+func PackageSetDetails(
+	ctx context.Context, pub Publisher, packageName string,
+	details ap.AppDetails, opts UpdateOptions) (*UpdateResult, error) {
+
+	return PackageUpdate(ctx, pub, packageName, UpdateOptions{
+		Details:          &details,
+		DryRun:           opts.DryRun,
+		NotSentForReview: opts.NotSentForReview,
+		EditId:           opts.EditId,
+		Progress:         opts.Progress,
+	})
+}
+
+// wantedDetails returns the details with the non empty fields of changes
+// put in.
+func wantedDetails(details, changes *ap.AppDetails) *ap.AppDetails {
+	wanted := *details
+	if changes.DefaultLanguage != "" {
+		wanted.DefaultLanguage = changes.DefaultLanguage
+	}
+	if changes.ContactEmail != "" {
+		wanted.ContactEmail = changes.ContactEmail
+	}
+	if changes.ContactWebsite != "" {
+		wanted.ContactWebsite = changes.ContactWebsite
+	}
+	if changes.ContactPhone != "" {
+		wanted.ContactPhone = changes.ContactPhone
+	}
+	return &wanted
+}
+
+// checkDefaultLanguage checks there is a listing for a new default
+// language, either in the edit or in added, the listings that are going to
+// be made.  The Play Store needs one.
+func checkDefaultLanguage(
+	ctx context.Context, pub Publisher, packageName, editId, bcp47 string,
+	added []*ap.Listing) error {
+
+	for _, listing := range added {
+		if listing.Language == bcp47 {
+			return nil
+		}
+	}
+	listed, err := pub.ListListings(ctx, packageName, editId)
+	if err != nil {
+		return fmt.Errorf("getting listings got %v", err)
+	}
+	for _, listing := range listed {
+		if listing.Language == bcp47 {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s has no %s listing for the default language",
+		packageName, bcp47)
+}
+
+// updateDetails updates the app details to wanted if they are different.
+// The action has the changes as a unified diff.  If dryRun is set the
+// changes are not made.  The action is nil if there are no changes.
+func updateDetails(
+	ctx context.Context, pub Publisher, w io.Writer,
+	editId, packageName string,
+	details, wanted *ap.AppDetails,
+	dryRun bool) (*Action, error) {
+
+	diff := detailsDiff(details, wanted)
+	if diff == "" {
+		fmt.Fprintf(w, "no details changes for %s\n", packageName)
+		return nil, nil
+	}
+	action := &Action{Kind: ActionUpdateDetails, Diff: diff}
+	if dryRun {
+		return action, nil
+	}
+
+	fmt.Fprintf(w, "update details %s\n", packageName)
+	_, err := pub.UpdateDetails(ctx, packageName, editId, wanted)
+	if err != nil {
+		return nil, fmt.Errorf("details update for %s got %v", packageName, err)
+	}
+	return action, nil
+}
@@ -0,0 +1,111 @@
+// diff_test.go
+// Tests the unified diff of listing changes.
+package androidpub
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// numberedLines returns lines "1" to "n", with changed ones replaced.
+func numberedLines(n int, changed map[int]string) string {
+	var lines []string
+	for i := 1; i <= n; i++ {
+		line := fmt.Sprint(i)
+		if c, ok := changed[i]; ok {
+			line = c
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	const header = "--- title (current)\n+++ title (new)\n"
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{
+			name: "same",
+			a:    "a\nb",
+			b:    "a\nb",
+			want: "",
+		},
+		{
+			name: "changed line",
+			a:    "a\nb\nc",
+			b:    "a\nB\nc",
+			want: header + "@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+		},
+		{
+			name: "from empty",
+			a:    "",
+			b:    "x",
+			want: header + "@@ -0,0 +1 @@\n+x\n",
+		},
+		{
+			name: "to empty",
+			a:    "x\ny",
+			b:    "",
+			want: header + "@@ -1,2 +0,0 @@\n-x\n-y\n",
+		},
+		{
+			name: "trailing newline ignored",
+			a:    "x\n",
+			b:    "x\ny\n",
+			want: header + "@@ -1 +1,2 @@\n x\n+y\n",
+		},
+		{
+			name: "context trimmed",
+			a:    numberedLines(10, nil),
+			b:    numberedLines(10, map[int]string{5: "five"}),
+			want: header +
+				"@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			name: "close changes in one hunk",
+			a:    numberedLines(10, nil),
+			b:    numberedLines(10, map[int]string{2: "two", 8: "eight"}),
+			want: header + "@@ -1,10 +1,10 @@\n 1\n-2\n+two\n 3\n 4\n 5\n" +
+				" 6\n 7\n-8\n+eight\n 9\n 10\n",
+		},
+		{
+			name: "far changes in two hunks",
+			a:    numberedLines(20, nil),
+			b:    numberedLines(20, map[int]string{2: "two", 19: "nineteen"}),
+			want: header +
+				"@@ -1,5 +1,5 @@\n 1\n-2\n+two\n 3\n 4\n 5\n" +
+				"@@ -16,5 +16,5 @@\n 16\n 17\n 18\n-19\n+nineteen\n 20\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := unifiedDiff("title", test.a, test.b)
+			if got != test.want {
+				t.Errorf("unifiedDiff got\n%s\nwant\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestHunkRange(t *testing.T) {
+	tests := []struct {
+		line, count int
+		want        string
+	}{
+		{1, 0, "0,0"},
+		{5, 0, "4,0"},
+		{3, 1, "3"},
+		{1, 4, "1,4"},
+		{12, 7, "12,7"},
+	}
+	for _, test := range tests {
+		if got := hunkRange(test.line, test.count); got != test.want {
+			t.Errorf("hunkRange(%d, %d) got %q, want %q",
+				test.line, test.count, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+// edits.go
+// Contains functions for making changes in one edit over several runs.  An
+// edit is made with PackageEdit, changed by PackageUpdate with
+// UpdateOptions.EditId, looked at with PackagePending and then committed
+// with PackageCommit or discarded with PackageDiscard.
+package androidpub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	ap "google.golang.org/api/androidpublisher/v3"
+)
+
+// PackageEdit makes an edit for the package that is left open.
+func PackageEdit(
+	ctx context.Context, pub Publisher, packageName string) (*EditInfo, error) {
+
+	session, err := NewEditSession(ctx, pub, packageName)
+	if err != nil {
+		return nil, err
+	}
+	session.Keep()
+	return &EditInfo{
+		PackageName: packageName,
+		EditId:      session.Id,
+		Expires:     session.Expires,
+	}, nil
+}
+
+// PackageCommit validates and commits an open edit.  If notSentForReview
+// is set the changes have to be sent for review from the Play Console.
+func PackageCommit(
+	ctx context.Context, pub Publisher, packageName, editId string,
+	notSentForReview bool) error {
+
+	session, err := ResumeEditSession(ctx, pub, packageName, editId)
+	if err != nil {
+		return err
+	}
+	return session.Commit(ctx, notSentForReview)
+}
+
+// PackageDiscard deletes an open edit and the changes made in it.
+func PackageDiscard(
+	ctx context.Context, pub Publisher, packageName, editId string) error {
+
+	return EditsDelete(ctx, pub, packageName, editId)
+}
+
+// PackagePending returns the changes in an open edit compared to the live
+// app.  The details, listings, images and tracks are compared.  If langs
+// are given only those listings and their images are.  The images are
+// listed parallel at a time.
+func PackagePending(
+	ctx context.Context, pub Publisher, packageName, editId string,
+	langs []string, parallel int) (*UpdateResult, error) {
+
+	if _, err := ResumeEditSession(ctx, pub, packageName, editId); err != nil {
+		return nil, err
+	}
+	// A new edit has the live app.
+	live, err := NewEditSession(ctx, pub, packageName)
+	if err != nil {
+		return nil, err
+	}
+	defer live.Close()
+	liveId := live.Id
+	result := &UpdateResult{
+		PackageName: packageName,
+		EditId:      editId,
+		Open:        true,
+	}
+
+	// Details
+	liveDetails, err := pub.GetDetails(ctx, packageName, liveId)
+	if err != nil {
+		return nil, fmt.Errorf("getting %s details got %v", packageName, err)
+	}
+	details, err := pub.GetDetails(ctx, packageName, editId)
+	if err != nil {
+		return nil, fmt.Errorf("getting %s edit details got %v",
+			packageName, err)
+	}
+	if diff := detailsDiff(liveDetails, details); diff != "" {
+		result.Actions = append(result.Actions, Action{
+			Kind: ActionUpdateDetails,
+			Diff: diff,
+		})
+	}
+
+	// Listings
+	liveListings, err := listings(ctx, pub, packageName, liveId, langs)
+	if err != nil {
+		return nil, err
+	}
+	liveListing := make(map[string]*ap.Listing)
+	for _, listing := range liveListings {
+		liveListing[listing.Language] = listing
+	}
+	listed, err := listings(ctx, pub, packageName, editId, langs)
+	if err != nil {
+		return nil, err
+	}
+	for _, listing := range listed {
+		old := liveListing[listing.Language]
+		if old == nil {
+			// A new listing.
+			old = &ap.Listing{Language: listing.Language}
+		}
+		if diff := listingDiff(old, listing); diff != "" {
+			result.Actions = append(result.Actions, Action{
+				Kind:     ActionUpdateListing,
+				Language: listing.Language,
+				Diff:     diff,
+			})
+		}
+	}
+
+	// Images, by locale and then type.
+	nTypes := len(GooglePlayImageTypes)
+	pending := make([][]Action, len(listed)*nTypes)
+	err = forEach(len(pending), parallel, func(i int) error {
+		bcp47 := listed[i/nTypes].Language
+		imageType := GooglePlayImageTypes[i%nTypes]
+		liveImages, err := pub.ListImages(
+			ctx, packageName, liveId, bcp47, imageType)
+		if err != nil {
+			return fmt.Errorf("image list for %s %s got %v",
+				bcp47, imageType, err)
+		}
+		images, err := pub.ListImages(
+			ctx, packageName, editId, bcp47, imageType)
+		if err != nil {
+			return fmt.Errorf("edit image list for %s %s got %v",
+				bcp47, imageType, err)
+		}
+		pending[i] = imageChanges(bcp47, imageType, liveImages, images)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, actions := range pending {
+		result.Actions = append(result.Actions, actions...)
+	}
+
+	// Tracks
+	liveTracks, err := pub.ListTracks(ctx, packageName, liveId)
+	if err != nil {
+		return nil, fmt.Errorf("getting %s tracks got %v", packageName, err)
+	}
+	liveReleases := make(map[string]string)
+	for _, track := range liveTracks {
+		liveReleases[track.Track] = releasesKey(track.Releases)
+	}
+	tracks, err := pub.ListTracks(ctx, packageName, editId)
+	if err != nil {
+		return nil, fmt.Errorf("getting %s edit tracks got %v",
+			packageName, err)
+	}
+	for _, track := range tracks {
+		if releasesKey(track.Releases) == liveReleases[track.Track] {
+			continue
+		}
+		for _, release := range track.Releases {
+			result.Actions = append(result.Actions, Action{
+				Kind:         ActionRelease,
+				Track:        track.Track,
+				Status:       release.Status,
+				UserFraction: release.UserFraction,
+				VersionCodes: release.VersionCodes,
+				Notes:        release.ReleaseNotes,
+			})
+		}
+	}
+
+	// Nothing was changed.
+	return result, live.Delete(ctx)
+}
+
+// detailsDiff returns the unified diff of the app details.
+func detailsDiff(details, wanted *ap.AppDetails) string {
+	text := func(d *ap.AppDetails) string {
+		return fmt.Sprintf(
+			"defaultLanguage: %s\ncontactEmail: %s\n"+
+				"contactPhone: %s\ncontactWebsite: %s\n",
+			d.DefaultLanguage, d.ContactEmail,
+			d.ContactPhone, d.ContactWebsite)
+	}
+	return unifiedDiff("details", text(details), text(wanted))
+}
+
+// imageChanges returns the changes going from the live images of a type to
+// the edit ones.  Images are matched by SHA1.
+func imageChanges(
+	bcp47, imageType string, live, images []*ap.Image) []Action {
+
+	isLive := make(map[string]bool)
+	for _, image := range live {
+		isLive[image.Sha1] = true
+	}
+	inEdit := make(map[string]bool)
+	for _, image := range images {
+		inEdit[image.Sha1] = true
+	}
+
+	var actions []Action
+	// The order of the images in both.
+	var liveOrder, order []string
+	for _, image := range live {
+		if inEdit[image.Sha1] {
+			liveOrder = append(liveOrder, image.Sha1)
+			continue
+		}
+		actions = append(actions, Action{
+			Kind:      ActionDeleteImage,
+			Language:  bcp47,
+			ImageType: imageType,
+			ImageId:   image.Id,
+			Sha1:      image.Sha1,
+		})
+	}
+	for _, image := range images {
+		if isLive[image.Sha1] {
+			order = append(order, image.Sha1)
+			continue
+		}
+		actions = append(actions, Action{
+			Kind:      ActionUploadImage,
+			Language:  bcp47,
+			ImageType: imageType,
+			ImageId:   image.Id,
+			Sha1:      image.Sha1,
+		})
+	}
+	if fmt.Sprint(liveOrder) != fmt.Sprint(order) {
+		actions = append([]Action{{
+			Kind:      ActionReorderImages,
+			Language:  bcp47,
+			ImageType: imageType,
+		}}, actions...)
+	}
+	return actions
+}
+
+// releasesKey returns a string that is the same for the same releases.
+func releasesKey(releases []*ap.TrackRelease) string {
+	bytes, _ := json.Marshal(releases)
+	return string(bytes)
+}
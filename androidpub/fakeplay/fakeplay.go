@@ -0,0 +1,768 @@
+// fakeplay.go
+// Contains a fake Google Play Developer API server for running the
+// androidpub functions without credentials or a real Play Console app.  It
+// keeps the apps in memory and implements the edits calls androidpub uses,
+// details, listings, images, tracks, bundles and apks, with edits working on
+// a copy of the app that replaces it when committed.
+//
+//	server := fakeplay.New()
+//	defer server.Close()
+//	server.SetApp("com.example.app", &fakeplay.App{...})
+//	pub, err := androidpub.GetPublisher(ctx, "", server.ClientOptions()...)
+//	...
+//	result, err := androidpub.PackageUpdate(ctx, pub, "com.example.app", opts)
+package fakeplay
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	ap "google.golang.org/api/androidpublisher/v3"
+	"google.golang.org/api/option"
+)
+
+const (
+	// apiPrefix is the path of the applications in the API.
+	apiPrefix = "/androidpublisher/v3/applications/"
+	// uploadPrefix is added before apiPrefix for media uploads.
+	uploadPrefix = "/upload"
+	// imagesPath is where the uploaded images are served.
+	imagesPath = "/images/"
+	// editLifetime is how long an edit lasts.  The Play Store keeps them
+	// for about an hour.
+	editLifetime = time.Hour
+)
+
+// standardTracks are the tracks every app has.
+var standardTracks = []string{"internal", "alpha", "beta", "production"}
+
+// App is the state of an app.  Images are by language and then image type.
+type App struct {
+	Details  ap.AppDetails
+	Listings map[string]*ap.Listing
+	Images   map[string]map[string][]*ap.Image
+	Tracks   map[string]*ap.Track
+	Bundles  []*ap.Bundle
+	Apks     []*ap.Apk
+	// ManagedPublishing apps can only be committed with
+	// changesNotSentForReview, the others can't be.
+	ManagedPublishing bool
+}
+
+// copy returns a deep copy of the app.
+func (app *App) copy() *App {
+	bytes, err := json.Marshal(app)
+	if err != nil {
+		panic(fmt.Sprintf("copying app got %v", err))
+	}
+	var c App
+	if err := json.Unmarshal(bytes, &c); err != nil {
+		panic(fmt.Sprintf("copying app got %v", err))
+	}
+	c.init()
+	return &c
+}
+
+// init makes the app maps that are nil and adds the standard tracks.
+func (app *App) init() {
+	if app.Listings == nil {
+		app.Listings = make(map[string]*ap.Listing)
+	}
+	if app.Images == nil {
+		app.Images = make(map[string]map[string][]*ap.Image)
+	}
+	if app.Tracks == nil {
+		app.Tracks = make(map[string]*ap.Track)
+	}
+	for _, name := range standardTracks {
+		if app.Tracks[name] == nil {
+			app.Tracks[name] = &ap.Track{Track: name}
+		}
+	}
+}
+
+// nextVersionCode returns the version code for a new bundle or apk.
+func (app *App) nextVersionCode() int64 {
+	code := int64(1)
+	for _, bundle := range app.Bundles {
+		if bundle.VersionCode >= code {
+			code = bundle.VersionCode + 1
+		}
+	}
+	for _, apk := range app.Apks {
+		if apk.VersionCode >= code {
+			code = apk.VersionCode + 1
+		}
+	}
+	return code
+}
+
+// edit is an open edit of an app.
+type edit struct {
+	packageName string
+	app         *App
+	expiry      time.Time
+}
+
+// Server is a fake Google Play Developer API server.
+type Server struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	apps    map[string]*App
+	edits   map[string]*edit
+	blobs   map[string][]byte // Image ID to image.
+	uploads map[string][]byte // Resumable upload ID to data so far.
+	commits map[string]int    // Package name to commits.
+	nextId  int
+
+	// Injected failures.
+	failures   int
+	failMade   int // Failures after the call is made.
+	failCode   int
+	retryAfter time.Duration
+}
+
+// New starts a fake server.  Close it when done.
+func New() *Server {
+	s := &Server{
+		apps:    make(map[string]*App),
+		edits:   make(map[string]*edit),
+		blobs:   make(map[string][]byte),
+		uploads: make(map[string][]byte),
+		commits: make(map[string]int),
+	}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// ClientOptions are the options for an Android Publisher service that uses
+// the server.
+func (s *Server) ClientOptions() []option.ClientOption {
+	return []option.ClientOption{
+		option.WithEndpoint(s.URL + "/"),
+		option.WithoutAuthentication(),
+	}
+}
+
+// SetApp sets the live state of an app, adding it if needed.
+func (s *Server) SetApp(packageName string, app *App) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.apps[packageName] = app.copy()
+}
+
+// App returns a copy of the live state of an app or nil if there isn't one.
+func (s *Server) App(packageName string) *App {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	app, ok := s.apps[packageName]
+	if !ok {
+		return nil
+	}
+	return app.copy()
+}
+
+// AddImage adds an image to the live state of an app.
+func (s *Server) AddImage(
+	packageName, language, imageType string, data []byte) *ap.Image {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	app, ok := s.apps[packageName]
+	if !ok {
+		app = &App{}
+		app.init()
+		s.apps[packageName] = app
+	}
+	return s.addImage(app, language, imageType, data)
+}
+
+// Commits returns the number of edits committed for a package.
+func (s *Server) Commits(packageName string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.commits[packageName]
+}
+
+// Fail makes the next n API calls fail with the HTTP status code.  If
+// retryAfter is set it is sent as the Retry-After header.
+func (s *Server) Fail(n, code int, retryAfter time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures, s.failCode, s.retryAfter = n, code, retryAfter
+}
+
+// FailMade makes the next n API calls fail with the HTTP status code after
+// they are made, like a server error that loses the response.
+func (s *Server) FailMade(n, code int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failMade, s.failCode, s.retryAfter = n, code, 0
+}
+
+// OpenEdits returns the number of edits that have not been committed or
+// deleted.
+func (s *Server) OpenEdits() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.edits)
+}
+
+// newId returns a new unique ID.
+func (s *Server) newId() string {
+	s.nextId++
+	return strconv.Itoa(s.nextId)
+}
+
+// addImage adds an image to an app.
+func (s *Server) addImage(
+	app *App, language, imageType string, data []byte) *ap.Image {
+
+	id := s.newId()
+	image := &ap.Image{
+		Id:     id,
+		Sha1:   fmt.Sprintf("%x", sha1.Sum(data)),
+		Sha256: fmt.Sprintf("%x", sha256.Sum256(data)),
+		Url:    s.URL + imagesPath + id,
+	}
+	s.blobs[id] = data
+	if app.Images[language] == nil {
+		app.Images[language] = make(map[string][]*ap.Image)
+	}
+	app.Images[language][imageType] = append(
+		app.Images[language][imageType], image)
+	return image
+}
+
+// ServeHTTP handles the API calls.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := r.URL.Path
+	if strings.HasPrefix(path, imagesPath) {
+		s.serveImage(w, strings.TrimPrefix(path, imagesPath))
+		return
+	}
+	if s.failures > 0 {
+		s.failures--
+		if s.retryAfter > 0 {
+			w.Header().Set("Retry-After",
+				strconv.Itoa(int(s.retryAfter.Seconds())))
+		}
+		writeError(w, s.failCode, "injected failure")
+		return
+	}
+	if s.failMade > 0 {
+		s.failMade--
+		s.serveApi(httptest.NewRecorder(), r)
+		writeError(w, s.failCode, "injected failure")
+		return
+	}
+	s.serveApi(w, r)
+}
+
+// serveApi handles an API call.
+func (s *Server) serveApi(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	upload := strings.HasPrefix(path, uploadPrefix+apiPrefix)
+	path = strings.TrimPrefix(path, uploadPrefix)
+	if !strings.HasPrefix(path, apiPrefix) {
+		writeError(w, http.StatusNotFound, "unknown path %s", r.URL.Path)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(path, apiPrefix), "/")
+	if len(parts) < 2 || parts[1] != "edits" {
+		writeError(w, http.StatusNotFound, "unknown path %s", r.URL.Path)
+		return
+	}
+	packageName := parts[0]
+	app, ok := s.apps[packageName]
+	if !ok {
+		writeError(w, http.StatusNotFound, "package not found: %s", packageName)
+		return
+	}
+	if len(parts) == 2 {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "%s edits", r.Method)
+			return
+		}
+		id := s.newId()
+		s.edits[id] = &edit{
+			packageName: packageName,
+			app:         app.copy(),
+			expiry:      time.Now().Add(editLifetime),
+		}
+		writeJson(w, s.appEdit(id))
+		return
+	}
+
+	editId, action := parts[2], ""
+	if i := strings.Index(editId, ":"); i >= 0 {
+		editId, action = editId[:i], editId[i+1:]
+	}
+	e, ok := s.edits[editId]
+	if ok && time.Now().After(e.expiry) {
+		delete(s.edits, editId)
+		ok = false
+	}
+	if !ok || e.packageName != packageName {
+		writeError(w, http.StatusNotFound, "edit not found: %s", editId)
+		return
+	}
+	if len(parts) == 3 {
+		s.serveEdit(w, r, editId, action)
+		return
+	}
+	switch parts[3] {
+	case "details":
+		s.serveDetails(w, r, e.app)
+	case "listings":
+		s.serveListings(w, r, e.app, parts[4:], upload)
+	case "tracks":
+		s.serveTracks(w, r, e.app, parts[4:])
+	case "bundles", "apks":
+		s.serveBinaries(w, r, e.app, parts[3], upload)
+	default:
+		writeError(w, http.StatusNotFound, "unknown path %s", r.URL.Path)
+	}
+}
+
+// appEdit returns the API edit for an edit ID.
+func (s *Server) appEdit(editId string) *ap.AppEdit {
+	return &ap.AppEdit{
+		Id: editId,
+		ExpiryTimeSeconds: strconv.FormatInt(
+			s.edits[editId].expiry.Unix(), 10),
+	}
+}
+
+// serveEdit handles get, delete, commit and validate of an edit.
+func (s *Server) serveEdit(
+	w http.ResponseWriter, r *http.Request, editId, action string) {
+
+	e := s.edits[editId]
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		writeJson(w, s.appEdit(editId))
+	case action == "" && r.Method == http.MethodDelete:
+		delete(s.edits, editId)
+		w.WriteHeader(http.StatusNoContent)
+	case action == "validate" && r.Method == http.MethodPost:
+		writeJson(w, s.appEdit(editId))
+	case action == "commit" && r.Method == http.MethodPost:
+		notSent := r.URL.Query().Get("changesNotSentForReview") == "true"
+		if e.app.ManagedPublishing && !notSent {
+			writeError(w, http.StatusBadRequest, "Changes cannot be sent "+
+				"for review automatically. Please set the query parameter "+
+				"changesNotSentForReview to true. Once committed, the "+
+				"changes in this edit can be sent for review from the "+
+				"Google Play Console UI.")
+			return
+		}
+		if !e.app.ManagedPublishing && notSent {
+			writeError(w, http.StatusBadRequest, "Changes are sent for "+
+				"review automatically. The query parameter "+
+				"changesNotSentForReview must not be set.")
+			return
+		}
+		appEdit := s.appEdit(editId)
+		s.apps[e.packageName] = e.app
+		s.commits[e.packageName]++
+		delete(s.edits, editId)
+		writeJson(w, appEdit)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "%s edit %s", r.Method, action)
+	}
+}
+
+// serveDetails handles the app details.
+func (s *Server) serveDetails(w http.ResponseWriter, r *http.Request, app *App) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPatch:
+		details := app.Details
+		if r.Method == http.MethodPut {
+			details = ap.AppDetails{}
+		}
+		if !readJson(w, r, &details) {
+			return
+		}
+		// Like Play the default language needs a listing.
+		if _, ok := app.Listings[details.DefaultLanguage]; !ok {
+			writeError(w, http.StatusBadRequest,
+				"no listing for default language %s", details.DefaultLanguage)
+			return
+		}
+		app.Details = details
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "%s details", r.Method)
+		return
+	}
+	writeJson(w, &app.Details)
+}
+
+// serveListings handles listings and their images.  parts are the path
+// parts after listings.
+func (s *Server) serveListings(
+	w http.ResponseWriter, r *http.Request,
+	app *App, parts []string, upload bool) {
+
+	switch len(parts) {
+	case 0:
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "%s listings", r.Method)
+			return
+		}
+		var list ap.ListingsListResponse
+		for _, listing := range app.Listings {
+			list.Listings = append(list.Listings, listing)
+		}
+		sort.Slice(list.Listings, func(i, j int) bool {
+			return list.Listings[i].Language < list.Listings[j].Language
+		})
+		writeJson(w, &list)
+	case 1:
+		s.serveListing(w, r, app, parts[0])
+	case 2:
+		s.serveImages(w, r, app, parts[0], parts[1], upload)
+	case 3:
+		language, imageType, imageId := parts[0], parts[1], parts[2]
+		if r.Method != http.MethodDelete {
+			writeError(w, http.StatusMethodNotAllowed, "%s image", r.Method)
+			return
+		}
+		images := app.Images[language][imageType]
+		for i, image := range images {
+			if image.Id == imageId {
+				app.Images[language][imageType] = append(
+					images[:i:i], images[i+1:]...)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, "image not found: %s", imageId)
+	default:
+		writeError(w, http.StatusNotFound, "unknown path %s", r.URL.Path)
+	}
+}
+
+// serveListing handles a single language listing.
+func (s *Server) serveListing(
+	w http.ResponseWriter, r *http.Request, app *App, language string) {
+
+	listing, ok := app.Listings[language]
+	switch r.Method {
+	case http.MethodGet:
+		if !ok {
+			writeError(w, http.StatusNotFound, "listing not found: %s", language)
+			return
+		}
+	case http.MethodDelete:
+		delete(app.Listings, language)
+		delete(app.Images, language)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodPut, http.MethodPatch:
+		if !ok || r.Method == http.MethodPut {
+			listing = &ap.Listing{}
+		}
+		if !readJson(w, r, listing) {
+			return
+		}
+		listing.Language = language
+		app.Listings[language] = listing
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "%s listing", r.Method)
+		return
+	}
+	writeJson(w, listing)
+}
+
+// serveImages handles list, upload and delete all of the images of a type.
+func (s *Server) serveImages(
+	w http.ResponseWriter, r *http.Request,
+	app *App, language, imageType string, upload bool) {
+
+	if _, ok := app.Listings[language]; !ok {
+		writeError(w, http.StatusNotFound, "listing not found: %s", language)
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet:
+		writeJson(w, &ap.ImagesListResponse{
+			Images: app.Images[language][imageType],
+		})
+	case r.Method == http.MethodDelete:
+		deleted := app.Images[language][imageType]
+		delete(app.Images[language], imageType)
+		writeJson(w, &ap.ImagesDeleteAllResponse{Deleted: deleted})
+	case r.Method == http.MethodPost && upload:
+		data, done := s.readUpload(w, r)
+		if !done {
+			return
+		}
+		image := s.addImage(app, language, imageType, data)
+		writeJson(w, &ap.ImagesUploadResponse{Image: image})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "%s images", r.Method)
+	}
+}
+
+// serveTracks handles the tracks.  parts are the path parts after tracks.
+func (s *Server) serveTracks(
+	w http.ResponseWriter, r *http.Request, app *App, parts []string) {
+
+	if len(parts) == 0 {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "%s tracks", r.Method)
+			return
+		}
+		var list ap.TracksListResponse
+		for _, track := range app.Tracks {
+			list.Tracks = append(list.Tracks, track)
+		}
+		sort.Slice(list.Tracks, func(i, j int) bool {
+			return list.Tracks[i].Track < list.Tracks[j].Track
+		})
+		writeJson(w, &list)
+		return
+	}
+	name := parts[0]
+	track, ok := app.Tracks[name]
+	switch r.Method {
+	case http.MethodGet:
+		if !ok {
+			writeError(w, http.StatusNotFound, "track not found: %s", name)
+			return
+		}
+	case http.MethodPut, http.MethodPatch:
+		if !ok || r.Method == http.MethodPut {
+			track = &ap.Track{}
+		}
+		if !readJson(w, r, track) {
+			return
+		}
+		for _, release := range track.Releases {
+			for _, code := range release.VersionCodes {
+				if !hasVersionCode(app, code) {
+					writeError(w, http.StatusBadRequest,
+						"version code %d not found", code)
+					return
+				}
+			}
+		}
+		track.Track = name
+		app.Tracks[name] = track
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "%s track", r.Method)
+		return
+	}
+	writeJson(w, track)
+}
+
+// hasVersionCode is true if a bundle or apk has the version code.
+func hasVersionCode(app *App, code int64) bool {
+	for _, bundle := range app.Bundles {
+		if bundle.VersionCode == code {
+			return true
+		}
+	}
+	for _, apk := range app.Apks {
+		if apk.VersionCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+// serveBinaries handles list and upload of bundles or apks.  Uploads get
+// the next version code.
+func (s *Server) serveBinaries(
+	w http.ResponseWriter, r *http.Request,
+	app *App, kind string, upload bool) {
+
+	switch {
+	case r.Method == http.MethodGet && kind == "bundles":
+		writeJson(w, &ap.BundlesListResponse{Bundles: app.Bundles})
+	case r.Method == http.MethodGet:
+		writeJson(w, &ap.ApksListResponse{Apks: app.Apks})
+	case r.Method == http.MethodPost && upload:
+		data, done := s.readUpload(w, r)
+		if !done {
+			return
+		}
+		code := app.nextVersionCode()
+		sha1 := fmt.Sprintf("%x", sha1.Sum(data))
+		sha256 := fmt.Sprintf("%x", sha256.Sum256(data))
+		if kind == "bundles" {
+			bundle := &ap.Bundle{VersionCode: code, Sha1: sha1, Sha256: sha256}
+			app.Bundles = append(app.Bundles, bundle)
+			writeJson(w, bundle)
+		} else {
+			apk := &ap.Apk{
+				VersionCode: code,
+				Binary:      &ap.ApkBinary{Sha1: sha1, Sha256: sha256},
+			}
+			app.Apks = append(app.Apks, apk)
+			writeJson(w, apk)
+		}
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "%s %s", r.Method, kind)
+	}
+}
+
+// serveImage serves the data of an uploaded image.
+func (s *Server) serveImage(w http.ResponseWriter, id string) {
+	data, ok := s.blobs[id]
+	if !ok {
+		http.NotFound(w, nil)
+		return
+	}
+	w.Header().Set("Content-Type", http.DetectContentType(data))
+	w.Write(data)
+}
+
+// readUpload reads the media of an upload.  Media and multipart uploads
+// are done in one request.  Resumable uploads start a session and then send
+// the media in chunks.  It is done when all the media has been read,
+// otherwise the response has been written.
+func (s *Server) readUpload(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	query := r.URL.Query()
+	if id := query.Get("upload_id"); id != "" {
+		return s.readChunk(w, r, id)
+	}
+	switch query.Get("uploadType") {
+	case "media":
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "reading upload got %v", err)
+			return nil, false
+		}
+		return data, true
+	case "multipart":
+		return readMultipart(w, r)
+	case "resumable":
+		id := s.newId()
+		s.uploads[id] = nil
+		query.Set("upload_id", id)
+		location := *r.URL
+		location.Scheme, location.Host = "http", r.Host
+		location.RawQuery = query.Encode()
+		w.Header().Set("Location", location.String())
+		w.WriteHeader(http.StatusOK)
+		return nil, false
+	}
+	writeError(w, http.StatusBadRequest,
+		"bad uploadType %s", query.Get("uploadType"))
+	return nil, false
+}
+
+// readChunk reads a chunk of a resumable upload.  The Content-Range header
+// has the total size on the last chunk.
+func (s *Server) readChunk(
+	w http.ResponseWriter, r *http.Request, id string) ([]byte, bool) {
+
+	data, ok := s.uploads[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "upload not found: %s", id)
+		return nil, false
+	}
+	chunk, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "reading upload got %v", err)
+		return nil, false
+	}
+	data = append(data, chunk...)
+	contentRange := r.Header.Get("Content-Range")
+	total := contentRange[strings.LastIndex(contentRange, "/")+1:]
+	if total != "*" && total == strconv.Itoa(len(data)) {
+		delete(s.uploads, id)
+		return data, true
+	}
+	s.uploads[id] = data
+	w.Header().Set("X-Http-Status-Code-Override", "308")
+	if len(data) > 0 {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(data)-1))
+	}
+	w.WriteHeader(http.StatusOK)
+	return nil, false
+}
+
+// readMultipart reads the media from a multipart/related upload.  The first
+// part is the JSON metadata and the second the media.
+func readMultipart(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad upload got %v", err)
+		return nil, false
+	}
+	reader := multipart.NewReader(r.Body, params["boundary"])
+	var data []byte
+	for n := 0; ; n++ {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "bad upload got %v", err)
+			return nil, false
+		}
+		bytes, err := ioutil.ReadAll(part)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "reading upload got %v", err)
+			return nil, false
+		}
+		if n == 1 {
+			data = bytes
+		}
+	}
+	return data, true
+}
+
+// readJson decodes the request body into v.  If it can't it writes an
+// error response and returns false.
+func readJson(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "decoding request got %v", err)
+		return false
+	}
+	return true
+}
+
+// writeJson writes v as the JSON response.
+func writeJson(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error response the way the API does so that it
+// becomes a googleapi.Error.
+func writeError(w http.ResponseWriter, code int, format string, a ...interface{}) {
+	message := fmt.Sprintf(format, a...)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    code,
+			"message": message,
+			"errors": []map[string]string{
+				{"message": message, "reason": http.StatusText(code)},
+			},
+		},
+	})
+}
@@ -0,0 +1,181 @@
+// fastlane.go
+// Contains functions for reading and writing the fastlane supply metadata
+// directory layout.  It is an alternative to the meaning ordered words files
+// where each locale has its own text files.
+//
+//	metadata/android/BCP47/title.txt
+//	metadata/android/BCP47/short_description.txt
+//	metadata/android/BCP47/full_description.txt
+//	metadata/android/BCP47/images/icon.png
+//	metadata/android/BCP47/images/phoneScreenshots/1_BCP47.png
+package androidpub
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	ap "google.golang.org/api/androidpublisher/v3"
+)
+
+const (
+	// FormatWords uses meaning ordered words files for text, translating
+	// the default language listing, and the images directory.
+	FormatWords = "words"
+	// FormatFastlane uses fastlane supply metadata directories.
+	FormatFastlane = "fastlane"
+
+	fastlaneTitle            = "title.txt"
+	fastlaneShortDescription = "short_description.txt"
+	fastlaneFullDescription  = "full_description.txt"
+	fastlaneImages           = "images"
+)
+
+// fastlaneSingleImages are the image types fastlane keeps as a single file
+// instead of a directory.
+var fastlaneSingleImages = map[string]bool{
+	"icon":           true,
+	"featureGraphic": true,
+	"tvBanner":       true,
+}
+
+// FastlaneLocales returns the BCP-47 locales that have a fastlane metadata
+// directory.
+func FastlaneLocales(metadataDir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(metadataDir)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s got %v", metadataDir, err)
+	}
+	var locales []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			locales = append(locales, entry.Name())
+		}
+	}
+	return locales, nil
+}
+
+// readFastlaneListing reads the fastlane text files for a locale.  Fields
+// without a file keep their value from current.  It returns nil if the
+// locale has no metadata directory.
+func readFastlaneListing(
+	metadataDir string, current *ap.Listing) (*ap.Listing, error) {
+
+	localeDir := filepath.Join(metadataDir, current.Language)
+	if !hasFastlaneLocale(metadataDir, current.Language) {
+		return nil, nil
+	}
+	listing := &ap.Listing{
+		Language:         current.Language,
+		Title:            current.Title,
+		ShortDescription: current.ShortDescription,
+		FullDescription:  current.FullDescription,
+	}
+	fields := []struct {
+		file  string
+		field *string
+	}{
+		{fastlaneTitle, &listing.Title},
+		{fastlaneShortDescription, &listing.ShortDescription},
+		{fastlaneFullDescription, &listing.FullDescription},
+	}
+	for _, f := range fields {
+		file := filepath.Join(localeDir, f.file)
+		bytes, err := ioutil.ReadFile(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading %s got %v", file, err)
+		}
+		*f.field = strings.TrimRight(string(bytes), "\r\n")
+	}
+	return listing, nil
+}
+
+// writeFastlaneListing writes the listing as fastlane text files.
+func writeFastlaneListing(metadataDir string, listing *ap.Listing) error {
+	localeDir := filepath.Join(metadataDir, listing.Language)
+	if err := os.MkdirAll(localeDir, 0755); err != nil {
+		return err
+	}
+	files := map[string]string{
+		fastlaneTitle:            listing.Title,
+		fastlaneShortDescription: listing.ShortDescription,
+		fastlaneFullDescription:  listing.FullDescription,
+	}
+	for name, text := range files {
+		file := filepath.Join(localeDir, name)
+		if err := ioutil.WriteFile(file, []byte(text), 0644); err != nil {
+			return fmt.Errorf("writing %s got %v", file, err)
+		}
+	}
+	return nil
+}
+
+// hasFastlaneLocale is true if the locale has a fastlane metadata directory.
+// The listings and images of locales without one are left as they are.
+func hasFastlaneLocale(metadataDir, bcp47 string) bool {
+	info, err := os.Stat(filepath.Join(metadataDir, bcp47))
+	return err == nil && info.IsDir()
+}
+
+// fastlaneImagePath returns the fastlane file name, without the extension,
+// for the n'th, from 0, image of a type.
+func fastlaneImagePath(metadataDir, bcp47, imageType string, n int) string {
+	imagesDir := filepath.Join(metadataDir, bcp47, fastlaneImages)
+	if fastlaneSingleImages[imageType] {
+		return filepath.Join(imagesDir, imageType)
+	}
+	return filepath.Join(
+		imagesDir, imageType, fmt.Sprintf("%d_%s", n+1, bcp47))
+}
+
+// fastlaneImageFiles returns the fastlane image files for a locale and type
+// in upload order.
+func fastlaneImageFiles(metadataDir, bcp47, imageType string) []string {
+	imagesDir := filepath.Join(metadataDir, bcp47, fastlaneImages)
+	var matches []string
+	if fastlaneSingleImages[imageType] {
+		// Glob only has errors for bad patterns.
+		matches, _ = filepath.Glob(filepath.Join(imagesDir, imageType+".*"))
+	} else {
+		matches, _ = filepath.Glob(filepath.Join(imagesDir, imageType, "*"))
+	}
+	var files []string
+	for _, match := range matches {
+		switch strings.ToLower(filepath.Ext(match)) {
+		case ".png", ".jpg", ".jpeg":
+			files = append(files, match)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// withFastlaneLocales adds empty listings for the fastlane locales that are
+// not yet listed.  Only langs are added if given.
+func withFastlaneLocales(
+	listed []*ap.Listing,
+	metadataDir string, langs []string) ([]*ap.Listing, error) {
+
+	locales, err := FastlaneLocales(metadataDir)
+	if err != nil {
+		return nil, err
+	}
+	have := make(map[string]bool)
+	for _, listing := range listed {
+		have[listing.Language] = true
+	}
+	for _, bcp47 := range locales {
+		listing := &ap.Listing{Language: bcp47}
+		if have[bcp47] || !useListing(langs, listing) {
+			continue
+		}
+		listed = append(listed, listing)
+	}
+	return listed, nil
+}
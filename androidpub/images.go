@@ -1,9 +1,41 @@
 // images.go
-// Android Publisher image constants and related information.
+// Android Publisher image constants, specs and checking image files against
+// them.
 // updated: January 22, 2022
 // see: https://developers.google.com/android-publisher/api-ref/rest/v3/AppImageType
 package androidpub
 
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/jpeg" // For image.Decode.
+	_ "image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const mb = 1024 * 1024
+
+// imageFileName matches the images directory naming convention,
+// LOCALE_#.EXT, where the _# is optional.  The LOCALE subtags are a
+// language, then script (Hant), region (US or 419) or variant ones.
+var imageFileName = regexp.MustCompile(
+	`^([A-Za-z]{2,3}(?:-(?:[A-Za-z]{2}|[0-9]{3}|[A-Za-z]{4}|[A-Za-z0-9]{5,8}))*)` +
+		`(?:_([0-9]+))?\.([A-Za-z]+)$`)
+
+// imageFormats are the image file extensions and their formats.
+var imageFormats = map[string]string{
+	".png":  "png",
+	".jpg":  "jpeg",
+	".jpeg": "jpeg",
+}
+
 var (
 	GooglePlayImageTypes = []string{
 		"phoneScreenshots",     // Phone screenshot.
@@ -26,9 +58,313 @@ var (
 		"featureGraphic":       "1 PNG or JPEG, up to 1MB, and 1,024px by 500px.",
 		"tvBanner":             "1 24bit PNG (no alpha) 1280x720",
 	}
+
+	// GooglePlayImageSpecs are what the Play Store accepts for each image
+	// type.
+	GooglePlayImageSpecs = map[string]ImageSpec{
+		"phoneScreenshots": {
+			MinCount: 2, MaxCount: 8, MaxBytes: 8 * mb,
+			MinSide: 320, MaxSide: 3840,
+			Aspects: []Aspect{{16, 9}, {9, 16}},
+			Formats: []string{"png", "jpeg"},
+		},
+		"sevenInchScreenshots": {
+			MaxCount: 8, MaxBytes: 8 * mb,
+			MinSide: 320, MaxSide: 3840,
+			Aspects: []Aspect{{16, 9}, {9, 16}},
+			Formats: []string{"png", "jpeg"},
+		},
+		"tenInchScreenshots": {
+			MaxCount: 8, MaxBytes: 8 * mb,
+			MinSide: 320, MaxSide: 3840,
+			Aspects: []Aspect{{16, 9}, {9, 16}},
+			Formats: []string{"png", "jpeg"},
+		},
+		"tvScreenshots": {
+			MaxCount: 8, MaxBytes: 8 * mb,
+			MinSide: 320, MaxSide: 3840,
+			Aspects: []Aspect{{16, 9}},
+			Formats: []string{"png", "jpeg"},
+		},
+		"wearScreenshots": {
+			MaxCount: 8, MaxBytes: 8 * mb,
+			MinSide: 384, MaxSide: 3840,
+			Aspects: []Aspect{{1, 1}},
+			Formats: []string{"png", "jpeg"},
+		},
+		"icon": {
+			MinCount: 1, MaxCount: 1, MaxBytes: 1 * mb,
+			Width: 512, Height: 512,
+			Alpha:   true,
+			Formats: []string{"png"},
+		},
+		"featureGraphic": {
+			MinCount: 1, MaxCount: 1, MaxBytes: 1 * mb,
+			Width: 1024, Height: 500,
+			Formats: []string{"png", "jpeg"},
+		},
+		"tvBanner": {
+			MaxCount: 1, MaxBytes: 1 * mb,
+			Width: 1280, Height: 720,
+			Formats: []string{"png"},
+		},
+	}
 )
 
 // GoogleImageTypeInfo returns a string describing the image type.
 func GoogleImageTypeInfo(imageType string) string {
 	return imageTypeInfo[imageType]
 }
+
+// ImageSpec is what the Play Store accepts for an image type.
+type ImageSpec struct {
+	MinCount int   // Fewest images a listing needs, 0 if they are optional.
+	MaxCount int   // Most images a listing can have.
+	MaxBytes int64 // Largest file size.
+	// Width and Height are the exact size in pixels, 0 for any size.
+	Width, Height int
+	// MinSide and MaxSide are the smallest and largest width or height in
+	// pixels, 0 for no limit.
+	MinSide, MaxSide int
+	// Aspects are the width to height ratios allowed, any if empty.
+	Aspects []Aspect
+	// Alpha is true if transparency is allowed.
+	Alpha bool
+	// Formats are the image.Decode format names allowed, png or jpeg.
+	Formats []string
+}
+
+// Aspect is a width to height ratio like 16:9.
+type Aspect struct {
+	Width, Height int
+}
+
+func (a Aspect) String() string {
+	return fmt.Sprintf("%d:%d", a.Width, a.Height)
+}
+
+// ImageProblem is something wrong with an image or the images of a type in
+// a locale.
+type ImageProblem struct {
+	Language  string // BCP-47 locale, empty for a single file.
+	ImageType string
+	File      string // Empty for a problem with the number of images.
+	Problem   string
+}
+
+func (p ImageProblem) String() string {
+	var parts []string
+	for _, part := range []string{p.Language, p.ImageType, p.File} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(append(parts, p.Problem), " ")
+}
+
+// ImageProblems is an error made from all the problems found in a set of
+// images.
+type ImageProblems []ImageProblem
+
+func (ps ImageProblems) Error() string {
+	lines := make([]string, len(ps))
+	for i, p := range ps {
+		lines[i] = p.String()
+	}
+	return fmt.Sprintf("%d image problems:\n%s",
+		len(ps), strings.Join(lines, "\n"))
+}
+
+// ValidateImage checks an image file against the GooglePlayImageSpecs for
+// imageType.  The file is decoded to check its format, size and
+// transparency.
+func ValidateImage(imageType, file string) []ImageProblem {
+	var problems []ImageProblem
+	add := func(format string, a ...interface{}) {
+		problems = append(problems, ImageProblem{
+			ImageType: imageType,
+			File:      file,
+			Problem:   fmt.Sprintf(format, a...),
+		})
+	}
+	spec, ok := GooglePlayImageSpecs[imageType]
+	if !ok {
+		add("is not a Play image type")
+		return problems
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		add("can't be read got %v", err)
+		return problems
+	}
+	if n := int64(len(data)); n > spec.MaxBytes {
+		add("is %d bytes, more than %d", n, spec.MaxBytes)
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		add("can't be decoded got %v", err)
+		return problems
+	}
+	if !contains(spec.Formats, format) {
+		add("is %s, not %s", format, strings.Join(spec.Formats, " or "))
+	}
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	if spec.Width != 0 && (width != spec.Width || height != spec.Height) {
+		add("is %dx%d, not %dx%d", width, height, spec.Width, spec.Height)
+	}
+	for _, side := range []int{width, height} {
+		if spec.MinSide != 0 && side < spec.MinSide {
+			add("is %dx%d, a side is less than %d",
+				width, height, spec.MinSide)
+			break
+		}
+		if spec.MaxSide != 0 && side > spec.MaxSide {
+			add("is %dx%d, a side is more than %d",
+				width, height, spec.MaxSide)
+			break
+		}
+	}
+	if len(spec.Aspects) != 0 && !hasAspect(width, height, spec.Aspects) {
+		add("is %dx%d, not %v", width, height, spec.Aspects)
+	}
+	if !spec.Alpha && !isOpaque(img) {
+		add("has transparency")
+	}
+	return problems
+}
+
+// ValidateImages checks the images of a type for a locale, both each file
+// and how many there are.  No files is fine as the Play Store then shows
+// the default language images.
+func ValidateImages(bcp47, imageType string, files []string) []ImageProblem {
+	problems := validateImageCount(bcp47, imageType, len(files))
+	for _, file := range files {
+		problems = append(problems, ValidateImage(imageType, file)...)
+	}
+	return problems
+}
+
+// validateImageCount checks there are the right number, n, of images of a
+// type for a locale.
+func validateImageCount(bcp47, imageType string, n int) []ImageProblem {
+	spec := GooglePlayImageSpecs[imageType]
+	problem := ""
+	switch {
+	case n == 0:
+	case n < spec.MinCount:
+		problem = fmt.Sprintf("has %d images, fewer than %d", n, spec.MinCount)
+	case spec.MaxCount != 0 && n > spec.MaxCount:
+		problem = fmt.Sprintf("has %d images, more than %d", n, spec.MaxCount)
+	}
+	if problem == "" {
+		return nil
+	}
+	return []ImageProblem{{
+		Language:  bcp47,
+		ImageType: imageType,
+		Problem:   problem,
+	}}
+}
+
+// hasAspect is true if width:height is one of the aspects.
+func hasAspect(width, height int, aspects []Aspect) bool {
+	for _, a := range aspects {
+		if width*a.Height == height*a.Width {
+			return true
+		}
+	}
+	return false
+}
+
+// isOpaque is true if the image has no transparent pixels.
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ImageFile is an image file named by the images directory convention,
+// LOCALE_#.EXT.  For instance en-US_2.png or it.jpg.
+type ImageFile struct {
+	Path   string
+	Locale string // BCP-47 or ISO-639 code.
+	Index  int    // Image number, 0 if the name has none.
+	Format string // png or jpeg.
+}
+
+// ParseImageFileName parses the name of an image file.  The extension must
+// be .png, .jpg or .jpeg.
+func ParseImageFileName(path string) (ImageFile, error) {
+	name := filepath.Base(path)
+	m := imageFileName.FindStringSubmatch(name)
+	if m == nil {
+		return ImageFile{}, fmt.Errorf(
+			"bad image file name %s want LOCALE_#.png, .jpg or .jpeg", path)
+	}
+	format, ok := imageFormats[strings.ToLower("."+m[3])]
+	if !ok {
+		return ImageFile{}, fmt.Errorf(
+			"bad image file %s extension want .png, .jpg or .jpeg", path)
+	}
+	index := 0
+	if m[2] != "" {
+		var err error
+		index, err = strconv.Atoi(m[2])
+		if err != nil {
+			return ImageFile{}, fmt.Errorf(
+				"bad image file %s number got %v", path, err)
+		}
+	}
+	return ImageFile{Path: path, Locale: m[1], Index: index, Format: format}, nil
+}
+
+// readImageDir parses the names of the image files, those with image
+// extensions, in dir.  They are returned by locale in index order.  A
+// missing dir has no images.
+func readImageDir(dir string) (map[string][]ImageFile, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var bad []string
+	byLocale := make(map[string][]ImageFile)
+	for _, entry := range entries {
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if entry.IsDir() || imageFormats[ext] == "" {
+			// Like README.md.
+			continue
+		}
+		file, err := ParseImageFileName(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			bad = append(bad, err.Error())
+			continue
+		}
+		byLocale[file.Locale] = append(byLocale[file.Locale], file)
+	}
+	for locale, files := range byLocale {
+		sort.Slice(files, func(i, j int) bool {
+			return files[i].Index < files[j].Index
+		})
+		for i := 1; i < len(files); i++ {
+			if files[i].Index == files[i-1].Index {
+				bad = append(bad, fmt.Sprintf(
+					"%s and %s are both %s image %d", files[i-1].Path,
+					files[i].Path, locale, files[i].Index))
+			}
+		}
+	}
+	if len(bad) != 0 {
+		sort.Strings(bad)
+		return nil, fmt.Errorf("%s", strings.Join(bad, "\n"))
+	}
+	return byLocale, nil
+}
@@ -0,0 +1,351 @@
+// images_test.go
+// Tests parsing image file names, reading image directories and validating
+// images.
+package androidpub
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseImageFileName(t *testing.T) {
+	tests := []struct {
+		path  string
+		want  ImageFile
+		isErr bool
+	}{
+		{path: "en-US_2.png",
+			want: ImageFile{Locale: "en-US", Index: 2, Format: "png"}},
+		{path: "it.jpg",
+			want: ImageFile{Locale: "it", Index: 0, Format: "jpeg"}},
+		{path: "en_0.png",
+			want: ImageFile{Locale: "en", Index: 0, Format: "png"}},
+		{path: "en-GB_0.png",
+			want: ImageFile{Locale: "en-GB", Index: 0, Format: "png"}},
+		{path: "es-419_1.jpeg",
+			want: ImageFile{Locale: "es-419", Index: 1, Format: "jpeg"}},
+		{path: filepath.Join("images", "icon", "zh-Hans-CN_10.JPG"),
+			want: ImageFile{Locale: "zh-Hans-CN", Index: 10, Format: "jpeg"}},
+		{path: "fil_3.png",
+			want: ImageFile{Locale: "fil", Index: 3, Format: "png"}},
+		{path: "shot.png", isErr: true},
+		{path: "en-US_a.png", isErr: true},
+		{path: "en-US-1.png", isErr: true},
+		{path: "en-US_1.gif", isErr: true},
+		{path: "en-US_1", isErr: true},
+		{path: "en-US_99999999999999999999.png", isErr: true},
+	}
+	for _, test := range tests {
+		got, err := ParseImageFileName(test.path)
+		if test.isErr {
+			if err == nil {
+				t.Errorf("ParseImageFileName(%q) got %+v, want an error",
+					test.path, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseImageFileName(%q) got %v", test.path, err)
+			continue
+		}
+		test.want.Path = test.path
+		if got != test.want {
+			t.Errorf("ParseImageFileName(%q) got %+v, want %+v",
+				test.path, got, test.want)
+		}
+	}
+}
+
+// makeFiles makes empty files, and directories for names ending in /, in
+// dir.
+func makeFiles(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if name[len(name)-1] == '/' {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadImageDir(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		// want is the file names by locale in order.
+		want  map[string][]string
+		isErr bool
+	}{
+		{
+			name: "language and locale",
+			files: []string{
+				"en_1.png", "en_0.png", "en-GB_0.png", "it.jpg",
+				"README.md", "old/",
+			},
+			want: map[string][]string{
+				"en":    {"en_0.png", "en_1.png"},
+				"en-GB": {"en-GB_0.png"},
+				"it":    {"it.jpg"},
+			},
+		},
+		{
+			name:  "index order",
+			files: []string{"de-DE_10.png", "de-DE_2.jpeg", "de-DE_0.png"},
+			want: map[string][]string{
+				"de-DE": {"de-DE_0.png", "de-DE_2.jpeg", "de-DE_10.png"},
+			},
+		},
+		{
+			name:  "same index",
+			files: []string{"en.png", "en_0.jpg"},
+			isErr: true,
+		},
+		{
+			name:  "bad name",
+			files: []string{"en_0.png", "screenshot.png"},
+			isErr: true,
+		},
+		{
+			name: "empty",
+			want: map[string][]string{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			makeFiles(t, dir, test.files...)
+			byLocale, err := readImageDir(dir)
+			if test.isErr {
+				if err == nil {
+					t.Errorf("readImageDir got %v, want an error", byLocale)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readImageDir got %v", err)
+			}
+			got := make(map[string][]string)
+			for locale, files := range byLocale {
+				for _, file := range files {
+					got[locale] = append(got[locale], filepath.Base(file.Path))
+				}
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("readImageDir got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestReadImageDirMissing(t *testing.T) {
+	byLocale, err := readImageDir(filepath.Join(t.TempDir(), "missing"))
+	if err != nil || len(byLocale) != 0 {
+		t.Errorf("readImageDir of a missing dir got %v %v", byLocale, err)
+	}
+}
+
+func TestLocalImageFilesLanguageFallback(t *testing.T) {
+	// en-GB has its own images, en-AU uses the en ones.
+	imagesDir := t.TempDir()
+	screenshots := filepath.Join(imagesDir, "phoneScreenshots")
+	makeFiles(t, imagesDir, "phoneScreenshots/")
+	makeFiles(t, screenshots, "en_0.png", "en_1.png", "en-GB_0.png")
+	tests := []struct {
+		bcp47  string
+		want   []string
+		upload bool
+	}{
+		{"en-US", []string{"en_0.png", "en_1.png"}, true},
+		{"en-GB", []string{"en-GB_0.png"}, true},
+		{"en-AU", []string{"en_0.png", "en_1.png"}, false},
+		{"de-DE", nil, true},
+	}
+	for _, test := range tests {
+		files, upload, err := localImageFiles(
+			imagesDir, FormatWords, "en-US", test.bcp47, "phoneScreenshots")
+		if err != nil {
+			t.Fatalf("%s: localImageFiles got %v", test.bcp47, err)
+		}
+		var got []string
+		for _, file := range files {
+			got = append(got, filepath.Base(file))
+		}
+		if !reflect.DeepEqual(got, test.want) || upload != test.upload {
+			t.Errorf("%s: localImageFiles got %v %v, want %v %v",
+				test.bcp47, got, upload, test.want, test.upload)
+		}
+	}
+}
+
+// writeTestImage writes a width by height image to file as format, png or
+// jpeg.  The image is half transparent if alpha is true.  The file is
+// padded with zeros to size bytes when size is bigger than the image.
+func writeTestImage(t *testing.T, file, format string,
+	width, height int, alpha bool, size int) {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	c := color.NRGBA{0x40, 0x80, 0xc0, 0xff}
+	if alpha {
+		c.A = 0x80
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(&buf, img)
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := size - buf.Len(); n > 0 {
+		buf.Write(make([]byte, n))
+	}
+	if err := ioutil.WriteFile(file, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateImage(t *testing.T) {
+	tests := []struct {
+		name          string
+		imageType     string
+		format        string
+		width, height int
+		alpha         bool
+		size          int
+		want          []string
+	}{
+		{name: "screenshot", imageType: "phoneScreenshots",
+			format: "png", width: 640, height: 360},
+		{name: "portrait screenshot", imageType: "phoneScreenshots",
+			format: "jpeg", width: 360, height: 640},
+		{name: "icon", imageType: "icon",
+			format: "png", width: 512, height: 512, alpha: true},
+		{name: "feature graphic", imageType: "featureGraphic",
+			format: "jpeg", width: 1024, height: 500},
+		{name: "tv banner", imageType: "tvBanner",
+			format: "png", width: 1280, height: 720},
+		{name: "wrong size", imageType: "featureGraphic",
+			format: "png", width: 1000, height: 500,
+			want: []string{"is 1000x500, not 1024x500"}},
+		{name: "wrong aspect", imageType: "phoneScreenshots",
+			format: "png", width: 400, height: 400,
+			want: []string{"is 400x400, not [16:9 9:16]"}},
+		{name: "too small", imageType: "phoneScreenshots",
+			format: "png", width: 160, height: 90,
+			want: []string{"is 160x90, a side is less than 320"}},
+		{name: "too big", imageType: "tvScreenshots",
+			format: "jpeg", width: 4000, height: 2250,
+			want: []string{"is 4000x2250, a side is more than 3840"}},
+		{name: "alpha tv banner", imageType: "tvBanner",
+			format: "png", width: 1280, height: 720, alpha: true,
+			want: []string{"has transparency"}},
+		{name: "jpeg icon", imageType: "icon",
+			format: "jpeg", width: 512, height: 512,
+			want: []string{"is jpeg, not png"}},
+		{name: "over max bytes", imageType: "icon",
+			format: "png", width: 512, height: 512, size: mb + 1,
+			want: []string{"is 1048577 bytes, more than 1048576"}},
+		{name: "several problems", imageType: "icon",
+			format: "jpeg", width: 500, height: 500, size: mb + 1,
+			want: []string{
+				"is 1048577 bytes, more than 1048576",
+				"is jpeg, not png",
+				"is 500x500, not 512x512",
+			}},
+		{name: "not a type", imageType: "banner",
+			format: "png", width: 512, height: 512,
+			want: []string{"is not a Play image type"}},
+	}
+	dir := t.TempDir()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := filepath.Join(dir, test.name+"."+test.format)
+			writeTestImage(t, file, test.format,
+				test.width, test.height, test.alpha, test.size)
+			var got []string
+			for _, problem := range ValidateImage(test.imageType, file) {
+				if problem.ImageType != test.imageType ||
+					problem.File != file {
+					t.Errorf("problem %+v, want type %s file %s",
+						problem, test.imageType, file)
+				}
+				got = append(got, problem.Problem)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("problems %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestValidateImageUndecodable(t *testing.T) {
+	dir := t.TempDir()
+	makeFiles(t, dir, "empty.png")
+	problems := ValidateImage("icon", filepath.Join(dir, "empty.png"))
+	if len(problems) != 1 ||
+		!strings.HasPrefix(problems[0].Problem, "can't be decoded") {
+		t.Errorf("problems %v, want can't be decoded", problems)
+	}
+}
+
+func TestValidateImageCount(t *testing.T) {
+	tests := []struct {
+		imageType string
+		n         int
+		want      string
+	}{
+		{"phoneScreenshots", 0, ""},
+		{"phoneScreenshots", 1, "has 1 images, fewer than 2"},
+		{"phoneScreenshots", 2, ""},
+		{"phoneScreenshots", 8, ""},
+		{"phoneScreenshots", 9, "has 9 images, more than 8"},
+		{"sevenInchScreenshots", 1, ""},
+		{"icon", 1, ""},
+		{"icon", 2, "has 2 images, more than 1"},
+		{"tvBanner", 0, ""},
+		{"tvBanner", 1, ""},
+		{"tvBanner", 2, "has 2 images, more than 1"},
+	}
+	for _, test := range tests {
+		problems := validateImageCount("en-US", test.imageType, test.n)
+		if test.want == "" {
+			if len(problems) != 0 {
+				t.Errorf("%s %d got %v, want none",
+					test.imageType, test.n, problems)
+			}
+			continue
+		}
+		want := []ImageProblem{{
+			Language:  "en-US",
+			ImageType: test.imageType,
+			Problem:   test.want,
+		}}
+		if !reflect.DeepEqual(problems, want) {
+			t.Errorf("%s %d got %v, want %v",
+				test.imageType, test.n, problems, want)
+		}
+	}
+}
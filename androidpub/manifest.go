@@ -0,0 +1,229 @@
+// manifest.go
+// Contains the store manifest, a YAML file describing the wanted state of
+// an app's store presence, and PackageApply which makes the app match it.
+package androidpub
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	ap "google.golang.org/api/androidpublisher/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// Manifest is the wanted state of an app.  Parts that are left out are not
+// changed.  For example:
+//
+//	package: com.example.app
+//	defaultLanguage: en-US
+//	contact:
+//	  email: support@example.com
+//	  website: https://example.com
+//	  phone: "+1 555 0100"
+//	locales: [en-US, de-DE, fr-FR]
+//	text:
+//	  format: words
+//	  dir: words
+//	  sub: update.sub
+//	images:
+//	  dir: images
+//	release:
+//	  track: internal
+//	  versionCodes: [42]
+//	  notes: |
+//	    Bug fixes.
+//
+// Relative paths are relative to the manifest file.
+type Manifest struct {
+	// Package is the package name of the app.
+	Package string `yaml:"package"`
+	// DefaultLanguage is the BCP-47 default language.  It needs a listing.
+	DefaultLanguage string `yaml:"defaultLanguage,omitempty"`
+	// Contact is the contact details shown in the store.
+	Contact ManifestContact `yaml:"contact,omitempty"`
+	// Locales are the BCP-47 listings to maintain.  Ones the app doesn't
+	// have are made, so text is needed.  Other listings are left as they
+	// are.  If empty all the app's listings are maintained.
+	Locales []string `yaml:"locales,omitempty"`
+	// Text is where the listing text comes from.
+	Text *ManifestSource `yaml:"text,omitempty"`
+	// Images is where the listing images come from.
+	Images *ManifestSource `yaml:"images,omitempty"`
+	// Release is put on its track.
+	Release *ManifestRelease `yaml:"release,omitempty"`
+	// NotSentForReview commits the changes without sending them for
+	// review.  Apps with managed publishing need it.
+	NotSentForReview bool `yaml:"notSentForReview,omitempty"`
+}
+
+// ManifestContact is the app contact details.
+type ManifestContact struct {
+	Email   string `yaml:"email,omitempty"`
+	Website string `yaml:"website,omitempty"`
+	Phone   string `yaml:"phone,omitempty"`
+}
+
+// ManifestSource is where text or images come from.
+type ManifestSource struct {
+	// Format is FormatWords, the default, or FormatFastlane.  Text and
+	// images must use the same format.
+	Format string `yaml:"format,omitempty"`
+	// Dir is the meaning ordered words directory for text, the images
+	// directory for images or the fastlane metadata directory.
+	Dir string `yaml:"dir"`
+	// Sub is the text substitutions file.  Optional.
+	Sub string `yaml:"sub,omitempty"`
+}
+
+// ManifestRelease is the release on a track.
+type ManifestRelease struct {
+	Track string `yaml:"track"`
+	// Bundle is an Android App Bundle (.aab) or APK (.apk) to upload.
+	Bundle string `yaml:"bundle,omitempty"`
+	// VersionCodes are already uploaded version codes to release.
+	VersionCodes []int64 `yaml:"versionCodes,omitempty"`
+	Name         string  `yaml:"name,omitempty"`
+	// Status is one of GooglePlayReleaseStatuses, completed if empty.
+	Status   string  `yaml:"status,omitempty"`
+	Fraction float64 `yaml:"fraction,omitempty"`
+	// Notes are the release notes in the default language.  They are
+	// translated using the words text dir.
+	Notes string `yaml:"notes,omitempty"`
+}
+
+// ReadManifest reads a manifest file.  Unknown keys are errors.
+func ReadManifest(manifestFile string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(manifestFile)
+	if err != nil {
+		return nil, err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var manifest Manifest
+	err = dec.Decode(&manifest)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("%s %v", manifestFile, err)
+	}
+	if manifest.Package == "" {
+		return nil, fmt.Errorf("%s needs a package", manifestFile)
+	}
+	return &manifest, nil
+}
+
+// UpdateOptions returns the options that make PackageUpdate apply the
+// manifest.  dir is what relative paths are relative to.  The options that
+// say how to update, like DryRun, are left for the caller.
+func (m *Manifest) UpdateOptions(dir string) (UpdateOptions, error) {
+	path := func(file string) string {
+		if file == "" || filepath.IsAbs(file) {
+			return file
+		}
+		return filepath.Join(dir, file)
+	}
+	opts := UpdateOptions{
+		Langs:            m.Locales,
+		NotSentForReview: m.NotSentForReview,
+		Format:           FormatWords,
+	}
+	if m.DefaultLanguage != "" || m.Contact != (ManifestContact{}) {
+		opts.Details = &ap.AppDetails{
+			DefaultLanguage: m.DefaultLanguage,
+			ContactEmail:    m.Contact.Email,
+			ContactWebsite:  m.Contact.Website,
+			ContactPhone:    m.Contact.Phone,
+		}
+	}
+	formats := make(map[string]bool)
+	for _, source := range []*ManifestSource{m.Text, m.Images} {
+		if source == nil {
+			continue
+		}
+		if source.Dir == "" {
+			return opts, fmt.Errorf("%s text and images need a dir", m.Package)
+		}
+		format := source.Format
+		if format == "" {
+			format = FormatWords
+		}
+		switch format {
+		case FormatWords:
+		case FormatFastlane:
+			if opts.MetadataDir != "" && opts.MetadataDir != path(source.Dir) {
+				return opts, fmt.Errorf(
+					"%s fastlane text and images need the same dir", m.Package)
+			}
+			opts.MetadataDir = path(source.Dir)
+		default:
+			return opts, fmt.Errorf("%s bad format %s", m.Package, format)
+		}
+		opts.Format = format
+		formats[format] = true
+	}
+	if len(formats) > 1 {
+		return opts, fmt.Errorf("%s text and images need the same format",
+			m.Package)
+	}
+	if m.Text != nil {
+		opts.DoText = true
+		opts.AddListings = len(m.Locales) != 0
+		opts.SubFile = path(m.Text.Sub)
+		if opts.Format == FormatWords {
+			opts.WordsDir = path(m.Text.Dir)
+		}
+	}
+	if m.Images != nil {
+		opts.DoImages = true
+		if opts.Format == FormatWords {
+			opts.ImagesDir = path(m.Images.Dir)
+		}
+	}
+	if r := m.Release; r != nil {
+		if r.Notes != "" && opts.WordsDir == "" {
+			return opts, fmt.Errorf("%s release notes need words text",
+				m.Package)
+		}
+		status := r.Status
+		if status == "" {
+			status = "completed"
+		}
+		opts.Release = &Release{
+			File:         path(r.Bundle),
+			VersionCodes: r.VersionCodes,
+			Track:        r.Track,
+			Name:         r.Name,
+			Status:       status,
+			UserFraction: r.Fraction,
+			Notes:        strings.TrimSpace(r.Notes),
+		}
+	}
+	return opts, nil
+}
+
+// PackageApply makes the app in the manifest file match it.  The listings
+// and images are synced the same way PackageUpdate does.  Only the DryRun,
+// Parallel, NotSentForReview, EditId and Progress fields of opts are used,
+// the rest come from the manifest.
+func PackageApply(
+	ctx context.Context, pub Publisher, manifestFile string,
+	opts UpdateOptions) (*UpdateResult, error) {
+
+	manifest, err := ReadManifest(manifestFile)
+	if err != nil {
+		return nil, err
+	}
+	apply, err := manifest.UpdateOptions(filepath.Dir(manifestFile))
+	if err != nil {
+		return nil, err
+	}
+	apply.DryRun = opts.DryRun
+	apply.Parallel = opts.Parallel
+	apply.NotSentForReview = apply.NotSentForReview || opts.NotSentForReview
+	apply.EditId = opts.EditId
+	apply.Progress = opts.Progress
+	return PackageUpdate(ctx, pub, manifest.Package, apply)
+}
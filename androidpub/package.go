@@ -4,255 +4,401 @@
 package androidpub
 
 import (
-	"bufio"
+	"context"
 	"crypto/sha1"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"strings"
 
 	xlns "github.com/napcatstudio/translate/v2"
 
 	ap "google.golang.org/api/androidpublisher/v3"
 )
 
-// PackageInfo write package info to the given io.Writer.
+// PackageInfo gets the package details, tracks, listings and the images of
+// each listing locale with their coverage.  If langs are given only those
+// locales are looked at, though the default language images are still
+// listed for the coverage.  The images are listed parallel at a time.
 func PackageInfo(
-	w io.Writer, credentialsJson, packageName string,
-	langs []string) error {
+	ctx context.Context, pub Publisher, packageName string,
+	langs []string, parallel int) (*Info, error) {
 
-	service, err := GetAPService(credentialsJson)
+	session, err := NewEditSession(ctx, pub, packageName)
 	if err != nil {
-		return fmt.Errorf("error %v", err)
-	}
-	editId, err := EditsInsert(service, packageName)
-	if err != nil {
-		return fmt.Errorf("error %v", err)
+		return nil, err
 	}
+	defer session.Close()
+	editId := session.Id
+	info := &Info{PackageName: packageName}
 
 	// Details
-	appDetails, err := service.Edits.Details.Get(packageName, editId).Do()
+	info.Details, err = pub.GetDetails(ctx, packageName, editId)
 	if err != nil {
-		return fmt.Errorf("getting %s details got %v", packageName, err)
+		return nil, fmt.Errorf("getting %s details got %v", packageName, err)
 	}
-	fmt.Fprintf(w, "%s %s\n%s\n%s\n",
-		packageName, appDetails.DefaultLanguage,
-		appDetails.ContactEmail,
-		appDetails.ContactWebsite)
-	defLang := appDetails.DefaultLanguage
 
 	// Tracks
-	tlr, err := service.Edits.Tracks.List(packageName, editId).Do()
+	info.Tracks, err = pub.ListTracks(ctx, packageName, editId)
 	if err != nil {
-		return fmt.Errorf("getting %s tracks got %v", packageName, err)
-	}
-	var myTracks []string
-	fmt.Println("tracks:")
-	for _, track := range tlr.Tracks {
-		fmt.Fprintf(w, "\t%s\n", track.Track)
-		myTracks = append(myTracks, track.Track)
+		return nil, fmt.Errorf("getting %s tracks got %v", packageName, err)
 	}
 
-	// Images
-	for _, imageType := range GooglePlayImageTypes {
-		fmt.Fprintf(w, "imageType: %s\n", imageType)
-		ilr, err := service.Edits.Images.List(
-			packageName, editId, defLang, imageType).Do()
+	// Listings
+	info.Listings, err = listings(ctx, pub, packageName, editId, langs)
+	if err != nil {
+		return nil, fmt.Errorf("getting %s listings got %v", packageName, err)
+	}
+
+	// Images, by locale and then type.  Locales without images of a type
+	// fall back to the default language ones, so those are always listed.
+	defLang := info.Details.DefaultLanguage
+	var locales []string
+	for _, listing := range info.Listings {
+		locales = append(locales, listing.Language)
+	}
+	onlyCoverage := !contains(locales, defLang)
+	if onlyCoverage {
+		locales = append(locales, defLang)
+	}
+	nTypes := len(GooglePlayImageTypes)
+	sets := make([]ImageSet, len(locales)*nTypes)
+	err = forEach(len(sets), parallel, func(i int) error {
+		bcp47 := locales[i/nTypes]
+		imageType := GooglePlayImageTypes[i%nTypes]
+		images, err := pub.ListImages(
+			ctx, packageName, editId, bcp47, imageType)
 		if err != nil {
-			return fmt.Errorf("getting %s %s images got %v", packageName, defLang, err)
+			return fmt.Errorf("getting %s %s %s images got %v",
+				packageName, bcp47, imageType, err)
 		}
-		if len(ilr.Images) == 0 {
-			fmt.Fprintf(w, "\tno images\n")
-			continue
+		if images == nil {
+			// An empty list, not null, in JSON.
+			images = []*ap.Image{}
 		}
-		for _, image := range ilr.Images {
-			fmt.Fprintf(w, "\timage.Id: %s\n", image.Id)
+		sets[i] = ImageSet{
+			Language:  bcp47,
+			ImageType: imageType,
+			Images:    images,
 		}
-	}
-
-	// Listings
-	listings, err := listings(service, packageName, editId, langs)
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("getting %s listings got %v", packageName, err)
+		return nil, err
 	}
-	for _, listing := range listings {
-		if !useListing(langs, listing) {
-			continue
-		}
-		fmt.Fprintf(w, "%s %s\n%s\n%s\n",
-			listing.Language, listing.Title,
-			listing.ShortDescription,
-			listing.FullDescription)
+	info.Images = sets
+	info.Coverage = imageCoverage(sets, defLang)
+	if onlyCoverage {
+		// The default language wasn't asked for.
+		info.Images = sets[:len(sets)-nTypes]
+		info.Coverage = info.Coverage[:len(info.Coverage)-1]
 	}
 
-	return nil
+	// Nothing was changed.
+	return info, session.Delete(ctx)
+}
+
+// UpdateOptions says what PackageUpdate should change.
+type UpdateOptions struct {
+	SubFile   string   // Translation substitutions file.
+	WordsDir  string   // Meaning ordered words directory.
+	ImagesDir string   // Images directory.
+	Langs     []string // BCP-47 locales to update, all if empty.
+	DoText    bool     // Update the listing text.
+	DoImages  bool     // Update the listing images.
+	// AddListings makes listings for the Langs the app doesn't have.  Their
+	// text comes from the words, or fastlane, files so DoText is needed.
+	AddListings bool
+	// Details, if set, are the wanted app details.  Empty fields are left
+	// as they are.  A new default language needs a listing.
+	Details *ap.AppDetails
+	// Format is where the text and images come from, FormatWords (the
+	// default) or FormatFastlane.
+	Format string
+	// MetadataDir is the fastlane metadata directory for FormatFastlane.
+	MetadataDir string
+	// DryRun only prints the changes, as diffs for text and SHA1s for
+	// images, and discards the edit instead of committing it.
+	DryRun bool
+	// Release, if set, is released in the same edit.
+	Release *Release
+	// Parallel is how many locales, and image types in a locale, are
+	// worked on at once.  The output is still in locale order.  0 is 1.
+	Parallel int
+	// NotSentForReview commits the changes without sending them for
+	// review, they are sent from the Play Console.  Apps with managed
+	// publishing need it.
+	NotSentForReview bool
+	// EditId, if set, is an open edit, made by PackageEdit, to make the
+	// changes in.  It is left open, to be committed or discarded later,
+	// instead of being committed.
+	EditId string
+	// Progress is where progress messages go, os.Stderr if nil.
+	Progress io.Writer
 }
 
 // PackageUpdate updates a Play Store Android package using the
-// AndroidPublisher API V3.
+// AndroidPublisher API V3.  The result lists the changes made, or that
+// would be made for a dry run.
 func PackageUpdate(
-	credentialsJson, packageName, subFile, wordsDir, imagesDir string,
-	langs []string,
-	do_text, do_images bool) error {
-	//	fmt.Printf(`credentials: %s
-	//words: %s
-	//images: %s
-	//update text: %v
-	//update images: %v
-	//`,
-	//		credentialsJson, wordsDir, imagesDir, do_text, do_images)
-
-	alternates, err := readSubstitutions(subFile)
-	if err != nil {
-		return err
+	ctx context.Context,
+	pub Publisher, packageName string,
+	opts UpdateOptions) (*UpdateResult, error) {
+
+	if opts.Progress == nil {
+		opts.Progress = os.Stderr
+	}
+	if opts.AddListings && !opts.DoText {
+		return nil, fmt.Errorf("adding listings needs text")
+	}
+	var subs substitutions
+	if opts.DoText && opts.SubFile != "" {
+		var err error
+		subs, err = readSubstitutions(opts.SubFile)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	service, err := GetAPService(credentialsJson)
-	if err != nil {
-		return fmt.Errorf("connecting to %s got %v", credentialsJson, err)
+	var session *EditSession
+	var err error
+	if opts.EditId == "" {
+		session, err = NewEditSession(ctx, pub, packageName)
+	} else {
+		session, err = ResumeEditSession(ctx, pub, packageName, opts.EditId)
 	}
-	editId, err := EditsInsert(service, packageName)
 	if err != nil {
-		return fmt.Errorf("getting edits insert got %v", err)
+		return nil, err
+	}
+	defer session.Close()
+	editId := session.Id
+	result := &UpdateResult{
+		PackageName: packageName,
+		EditId:      editId,
+		DryRun:      opts.DryRun,
 	}
 
 	// Details
-	appDetails, err := service.Edits.Details.Get(packageName, editId).Do()
+	appDetails, err := pub.GetDetails(ctx, packageName, editId)
 	if err != nil {
-		return fmt.Errorf("getting %s details got %v", packageName, err)
+		return nil, fmt.Errorf("getting %s details got %v", packageName, err)
 	}
-	fmt.Printf("%s default lang:%s\ne-mail:%s\nwebsite:%s\n",
-		packageName, appDetails.DefaultLanguage,
-		appDetails.ContactEmail,
-		appDetails.ContactWebsite)
+	fmt.Fprintf(opts.Progress, "%s default lang:%s\n",
+		packageName, appDetails.DefaultLanguage)
 	// Finish setting up info.
 	defBcp47 := appDetails.DefaultLanguage
 
-	listings, err := listings(service, packageName, editId, langs)
-	if err != nil {
-		return err
+	var listed []*ap.Listing
+	// The listings made by this update.
+	var added []*ap.Listing
+	needsNotes := opts.Release != nil && opts.Release.Notes != ""
+	if opts.DoText || opts.DoImages || needsNotes {
+		listed, err = listings(ctx, pub, packageName, editId, opts.Langs)
+		if err != nil {
+			return nil, err
+		}
+		have := len(listed)
+		if opts.DoText && opts.Format == FormatFastlane {
+			listed, err = withFastlaneLocales(
+				listed, opts.MetadataDir, opts.Langs)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if opts.AddListings {
+			listed = withLocales(listed, opts.Langs)
+		}
+		added = listed[have:]
+		if len(listed) == 0 {
+			return nil, fmt.Errorf("no listings")
+		}
+		if len(opts.Langs) != 0 && len(listed) != len(opts.Langs) {
+			return nil, fmt.Errorf("bad language in %v", opts.Langs)
+		}
 	}
-	if len(listings) == 0 {
-		return fmt.Errorf("no listings")
+	// Work out all the text, and check it and the images, before changing
+	// anything.
+	var wanted map[string]*ap.Listing
+	if opts.DoText {
+		wanted, err = wantedListings(
+			ctx, pub, editId, packageName, defBcp47, listed, opts, subs)
+		if err != nil {
+			return nil, err
+		}
 	}
-	if len(langs) != 0 && len(listings) != len(langs) {
-		return fmt.Errorf("bad language in %v", langs)
+	imagesDir := opts.ImagesDir
+	if opts.Format == FormatFastlane {
+		imagesDir = opts.MetadataDir
 	}
-
-	// By locale.
-	needsCommit := false
-	for i, listing := range listings {
-		// Output BCP-47.
-		fmt.Printf("%s (%d/%d)\n", listing.Language, i+1, len(listings))
-
-		if do_text {
-			if defBcp47 == listing.Language {
-				fmt.Printf("default not changing %s\n", defBcp47)
-			} else {
-				commit, err := updateDescriptions(
-					service, editId,
-					packageName, wordsDir,
-					defBcp47, listing.Language, alternates)
-				if err != nil {
-					return err
-				}
-				if commit {
-					needsCommit = true
-				}
-			}
+	if opts.DoImages {
+		var locales []string
+		for _, listing := range listed {
+			locales = append(locales, listing.Language)
 		}
-
-		if do_images {
-			commit, err := updateImages(
-				service, editId, packageName, imagesDir, defBcp47, listing.Language)
+		err := checkLocalImages(imagesDir, opts.Format, defBcp47, locales)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var details *ap.AppDetails
+	if opts.Details != nil {
+		details = wantedDetails(appDetails, opts.Details)
+		if details.DefaultLanguage != defBcp47 {
+			err := checkDefaultLanguage(ctx, pub, packageName, editId,
+				details.DefaultLanguage, added)
 			if err != nil {
-				return err
+				return nil, err
 			}
-			if commit {
-				needsCommit = true
+		}
+	}
+	var notes []*ap.LocalizedText
+	if opts.Release != nil {
+		if err := opts.Release.check(); err != nil {
+			return nil, err
+		}
+		if needsNotes {
+			var locales []string
+			for _, listing := range listed {
+				locales = append(locales, listing.Language)
+			}
+			notes, err = releaseNotes(
+				opts.WordsDir, defBcp47, opts.Release.Notes, locales)
+			if err != nil {
+				return nil, err
 			}
 		}
 	}
 
-	if needsCommit {
-		commit := service.Edits.Commit(packageName, editId)
-		//commit.ChangesNotSentForReview(true)
-		//err := EditsCommit(service, packageName, editId)
-		//editsCommitCall := ap.EditsCommitCall(packageName, editId)
-		//editsCommitCall.ChangesNotSentForReview(true)
-		_, err := commit.Do()
+	if opts.DoText || opts.DoImages {
+		isAdded := make(map[string]bool)
+		for _, listing := range added {
+			isAdded[listing.Language] = true
+		}
+		// By locale, opts.Parallel at a time.
+		actions := make([][]Action, len(listed))
+		err := forEachOrdered(opts.Progress, len(listed), opts.Parallel,
+			func(i int, w io.Writer) error {
+				listing := listed[i]
+				// Output BCP-47.
+				fmt.Fprintf(w, "%s (%d/%d)\n",
+					listing.Language, i+1, len(listed))
+
+				if opts.DoText {
+					if want := wanted[listing.Language]; want == nil {
+						fmt.Fprintf(w, "not changing %s\n", listing.Language)
+					} else {
+						action, err := putListing(
+							ctx, pub, w, editId, packageName, listing, want,
+							opts.DryRun)
+						if err != nil {
+							return err
+						}
+						if action != nil {
+							actions[i] = append(actions[i], *action)
+						}
+					}
+				}
+
+				if opts.DoImages {
+					done, err := updateImages(
+						ctx, pub, w, editId, packageName, imagesDir,
+						opts.Format, defBcp47, listing.Language,
+						opts.DryRun && isAdded[listing.Language],
+						opts.Parallel, opts.DryRun)
+					if err != nil {
+						return err
+					}
+					actions[i] = append(actions[i], done...)
+				}
+				return nil
+			})
+		for _, done := range actions {
+			result.Actions = append(result.Actions, done...)
+		}
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
-	return nil
-}
 
-// readSubstitutions reads a translation substitution file into a map.  If
-// there is not a file it prints a warning and returns an empty map.
-func readSubstitutions(subFile string) (map[string]string, error) {
-	subs := make(map[string]string)
-	f, err := os.Open(subFile)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "warning: no substitution file '%s'", subFile)
-		return subs, nil
-	}
-	defer f.Close()
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		toks := strings.Split(line, ":")
-		if len(toks) == 0 {
-			// Blank line.
-			continue
+	if details != nil {
+		action, err := updateDetails(
+			ctx, pub, opts.Progress, editId, packageName, appDetails, details,
+			opts.DryRun)
+		if err != nil {
+			return nil, err
 		}
-		if len(toks) != 2 {
-			return nil, fmt.Errorf("bad substitution '%s' in %s", line, subFile)
+		if action != nil {
+			result.Actions = append(result.Actions, *action)
 		}
-		// Original to alternate.
-		subs[toks[0]] = toks[1]
 	}
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("reading %s got %v", subFile, err)
+
+	if opts.Release != nil {
+		done, err := updateRelease(
+			ctx, pub, opts.Progress, editId, packageName, opts.Release, notes,
+			opts.DryRun)
+		if err != nil {
+			return nil, err
+		}
+		result.Actions = append(result.Actions, done...)
+	}
+
+	if opts.EditId != "" {
+		// Committed, or discarded, later.
+		result.Open = !opts.DryRun
+		return result, nil
 	}
-	return subs, nil
+	if opts.DryRun || len(result.Actions) == 0 {
+		return result, session.Delete(ctx)
+	}
+	// Check the whole edit before committing it.
+	if err := session.Commit(ctx, opts.NotSentForReview); err != nil {
+		return nil, err
+	}
+	result.Committed = true
+	result.NotSentForReview = opts.NotSentForReview
+	return result, nil
 }
 
 // PackageUpdateText updates a Play Store Android package text details using
 // the AndroidPublisher API V3.
 func PackageUpdateText(
-	credentialsJson, packageName, subFile, wordsDir string,
-	langs []string) error {
-
-	return PackageUpdate(
-		credentialsJson, packageName, subFile, wordsDir, "", langs, true, false)
+	ctx context.Context, pub Publisher, packageName, subFile, wordsDir string,
+	langs []string) (*UpdateResult, error) {
+
+	return PackageUpdate(ctx, pub, packageName, UpdateOptions{
+		SubFile:  subFile,
+		WordsDir: wordsDir,
+		Langs:    langs,
+		DoText:   true,
+	})
 }
 
-// PackageUpdateText updates a Play Store Android package text details using
+// PackageUpdateImages updates a Play Store Android package images using
 // the AndroidPublisher API V3.
 func PackageUpdateImages(
-	credentialsJson, packageName, imagesDir string,
-	langs []string) error {
-
-	return PackageUpdate(
-		credentialsJson, packageName, "", "", imagesDir, langs, false, true)
+	ctx context.Context, pub Publisher, packageName, imagesDir string,
+	langs []string) (*UpdateResult, error) {
+
+	return PackageUpdate(ctx, pub, packageName, UpdateOptions{
+		ImagesDir: imagesDir,
+		Langs:     langs,
+		DoImages:  true,
+	})
 }
 
 // listings returns the listings currently available in the Play Store.
 func listings(
-	service *ap.Service,
+	ctx context.Context, pub Publisher,
 	packageName, editId string,
 	langs []string) ([]*ap.Listing, error) {
 
-	listings, err := service.Edits.Listings.List(
-		packageName, editId).Do()
+	listings, err := pub.ListListings(ctx, packageName, editId)
 	if err != nil {
 		return nil, fmt.Errorf("getting listings got %v", err)
 	}
 	var ls []*ap.Listing
-	for _, listing := range listings.Listings {
+	for _, listing := range listings {
 		if !useListing(langs, listing) {
 			continue
 		}
@@ -261,6 +407,22 @@ func listings(
 	return ls, nil
 }
 
+// withLocales adds empty listings for the locales that are not yet listed.
+func withLocales(listed []*ap.Listing, locales []string) []*ap.Listing {
+	have := make(map[string]bool)
+	for _, listing := range listed {
+		have[listing.Language] = true
+	}
+	for _, bcp47 := range locales {
+		if have[bcp47] {
+			continue
+		}
+		have[bcp47] = true
+		listed = append(listed, &ap.Listing{Language: bcp47})
+	}
+	return listed
+}
+
 func useListing(langs []string, listing *ap.Listing) bool {
 	if len(langs) == 0 {
 		// No restrictions use them all.
@@ -276,68 +438,153 @@ func useListing(langs []string, listing *ap.Listing) bool {
 	return false
 }
 
-// updateDescription updates the description information for a package for
-// each BCP-47 location it has information for.
-func updateDescriptions(
-	service *ap.Service, editId,
-	packageName, wordsDir,
-	defBcp47, bcp47 string,
-	alternates map[string]string) (bool, error) {
+// wantedListings works out the listing text wanted for each locale and
+// checks it all.  Locales that are not changing, like the default language
+// when translating, are left out.
+func wantedListings(
+	ctx context.Context, pub Publisher, editId, packageName, defBcp47 string,
+	listed []*ap.Listing,
+	opts UpdateOptions,
+	subs substitutions) (map[string]*ap.Listing, error) {
 
 	// Get the base language listing.
-	baseListing, err := service.Edits.Listings.Get(
-		packageName, editId, defBcp47).Do()
+	base, err := pub.GetListing(ctx, packageName, editId, defBcp47)
 	if err != nil {
-		return false, fmt.Errorf("getting edit listing for %s got %v", bcp47, err)
+		return nil, fmt.Errorf("getting edit listing for %s got %v", defBcp47, err)
+	}
+
+	wanted := make(map[string]*ap.Listing)
+	var checks []*ap.Listing
+	var used []substitution
+	for _, listing := range listed {
+		var want *ap.Listing
+		if opts.Format == FormatFastlane {
+			want, err = readFastlaneListing(opts.MetadataDir, listing)
+		} else if listing.Language != defBcp47 {
+			var alts []substitution
+			want, alts, err = translateListing(
+				opts.WordsDir, base, listing.Language, subs)
+			used = append(used, alts...)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if want == nil {
+			continue
+		}
+		if want.Language == defBcp47 {
+			base = want
+		}
+		wanted[listing.Language] = want
+		checks = append(checks, want)
 	}
+	printAlternates(opts.Progress, used)
+	if err := validateListings(checks, base); err != nil {
+		return nil, err
+	}
+	return wanted, nil
+}
 
-	baseLang, err := langToUse(wordsDir, defBcp47)
+// translateListing translates the base listing into the bcp47 locale using
+// the meaning ordered words files.  If a field has an alternate in subs it is
+// translated instead when the translation is too long.  The alternates that
+// were used are returned.
+func translateListing(
+	wordsDir string, base *ap.Listing, bcp47 string,
+	subs substitutions) (*ap.Listing, []substitution, error) {
+
+	baseLang, err := langToUse(wordsDir, base.Language)
 	if err != nil {
-		return false, err
+		return nil, nil, err
 	}
 	lang, err := langToUse(wordsDir, bcp47)
 	if err != nil {
-		return false, err
+		return nil, nil, err
 	}
 
 	// Create translation map.
 	xm, err := xlns.WordsXlnsMap(wordsDir, baseLang, lang)
 	if err != nil {
-		return false, fmt.Errorf("%s %s to %s problem got %v",
+		return nil, nil, fmt.Errorf("%s %s to %s problem got %v",
 			wordsDir, baseLang, lang, err)
 	}
 
-	// Check if update is needed.
-	// Read existing.
-	listing, err := service.Edits.Listings.Get(
-		packageName, editId, bcp47).Do()
-	if err != nil {
-		return false, fmt.Errorf("get listing for %s failed %v", bcp47, err)
+	translated := &ap.Listing{Language: bcp47}
+	fields := []struct {
+		name, text string
+		max        int
+		out        *string
+	}{
+		{fieldTitle, base.Title, maxTitleLength, &translated.Title},
+		{fieldShortDescription, base.ShortDescription,
+			maxShortDescriptionLength, &translated.ShortDescription},
+		{fieldFullDescription, base.FullDescription,
+			maxFullDescriptionLength, &translated.FullDescription},
+	}
+	var used []substitution
+	for _, f := range fields {
+		alt := subs.alternate(bcp47, f.name, f.text)
+		if alt == "" && f.name != fieldTitle {
+			*f.out = xm.TranslateByLine(f.text)
+			continue
+		}
+		*f.out = xm.TranslateByLineWithAlternate(f.text, alt, f.max)
+		if alt != "" && *f.out != xm.TranslateByLine(f.text) {
+			used = append(used, substitution{
+				Locale:    bcp47,
+				Field:     f.name,
+				Original:  f.text,
+				Alternate: alt,
+			})
+		}
 	}
+	return translated, used, nil
+}
 
-	altTitle := alternates[baseListing.Title]
-	translated := ap.Listing{
-		Language:         bcp47,
-		Title:            xm.TranslateByLineWithAlternate(baseListing.Title, altTitle, 30),
-		ShortDescription: xm.TranslateByLine(baseListing.ShortDescription),
-		FullDescription:  xm.TranslateByLine(baseListing.FullDescription),
-	}
+// putListing updates the listing to wanted if they are different.  The
+// action has the changes as unified diffs.  If dryRun is set the changes are
+// not made.  The action is nil if there are no changes.
+func putListing(
+	ctx context.Context, pub Publisher, w io.Writer,
+	editId, packageName string,
+	listing, wanted *ap.Listing,
+	dryRun bool) (*Action, error) {
 
+	bcp47 := wanted.Language
 	// Compare.
-	isTheSame := listing.Title == translated.Title &&
-		listing.ShortDescription == translated.ShortDescription &&
-		listing.FullDescription == translated.FullDescription
+	isTheSame := listing.Title == wanted.Title &&
+		listing.ShortDescription == wanted.ShortDescription &&
+		listing.FullDescription == wanted.FullDescription
 	if isTheSame {
-		fmt.Printf("no listing changes for %s\n", bcp47)
-		return false, nil
+		fmt.Fprintf(w, "no listing changes for %s\n", bcp47)
+		return nil, nil
+	}
+
+	action := &Action{
+		Kind:     ActionUpdateListing,
+		Language: bcp47,
+		Diff:     listingDiff(listing, wanted),
+	}
+	if dryRun {
+		return action, nil
 	}
 
-	_, err = service.Edits.Listings.Update(
-		packageName, editId, bcp47, &translated).Do()
+	fmt.Fprintf(w, "update listing %s\n", bcp47)
+	_, err := pub.UpdateListing(ctx, packageName, editId, bcp47, wanted)
 	if err != nil {
-		return false, fmt.Errorf("listing update for %s got %v", bcp47, err)
+		return nil, fmt.Errorf("listing update for %s got %v", bcp47, err)
 	}
-	return true, nil
+	return action, nil
+}
+
+// listingDiff returns the unified diffs of the listing fields.
+func listingDiff(listing, wanted *ap.Listing) string {
+	bcp47 := wanted.Language
+	return unifiedDiff(bcp47+"/title", listing.Title, wanted.Title) +
+		unifiedDiff(bcp47+"/shortDescription",
+			listing.ShortDescription, wanted.ShortDescription) +
+		unifiedDiff(bcp47+"/fullDescription",
+			listing.FullDescription, wanted.FullDescription)
 }
 
 func langToUse(wordsDir, bcp47 string) (string, error) {
@@ -351,101 +598,265 @@ func langToUse(wordsDir, bcp47 string) (string, error) {
 
 type shotInfo struct {
 	file, sha1 string
-	image      *ap.Image
 }
 
-// updateImages checks for image updates.
-func updateImages(
-	service *ap.Service, editId,
-	packageName, imagesDir,
-	defBcp47, bcp47 string) (bool, error) {
+// imageSync is how to change the images of a type to the local ones.
+type imageSync struct {
+	deleteAll bool        // Delete all the images first.
+	toDelete  []*ap.Image // Or delete these.
+	toUpload  []shotInfo  // Then upload these, in order.
+	// reordered is true if images are deleted only to be uploaded again
+	// in a different order.
+	reordered bool
+}
 
-	defIso639 := xlns.Iso639FromBcp47(defBcp47) // en-US -> en
-	iso639 := xlns.Iso639FromBcp47(bcp47)       // en-GB -> en
-	isDefLocale := defBcp47 == bcp47            // en-US and en-US
-	isDifferentLang := iso639 != defIso639
+// planImageSync works out how to make the current images the same, and in
+// the same order, as the local ones.  New images can only be added at the
+// end so the longest start of the local images that is in the current ones,
+// in order, is kept.  The rest is deleted and uploaded, using Deleteall if
+// that is fewer calls.  If upload is false the local images are only used
+// to decide what to keep, nothing is uploaded and the order doesn't matter.
+func planImageSync(
+	current []*ap.Image, local []shotInfo, upload bool) imageSync {
+
+	var sync imageSync
+	if !upload {
+		wanted := make(map[string]bool)
+		for _, si := range local {
+			wanted[si.sha1] = true
+		}
+		for _, image := range current {
+			if !wanted[image.Sha1] {
+				sync.toDelete = append(sync.toDelete, image)
+			}
+		}
+		return sync
+	}
+
+	// Keep the longest start of local that is in current in order.
+	kept := 0
+	keep := make(map[*ap.Image]bool)
+	for _, image := range current {
+		if kept < len(local) && image.Sha1 == local[kept].sha1 {
+			keep[image] = true
+			kept++
+		}
+	}
+	sync.toUpload = local[kept:]
+	again := make(map[string]bool)
+	for _, si := range sync.toUpload {
+		again[si.sha1] = true
+	}
+	for _, image := range current {
+		if keep[image] {
+			continue
+		}
+		sync.toDelete = append(sync.toDelete, image)
+		if again[image.Sha1] {
+			sync.reordered = true
+		}
+	}
+	deleteAllCalls := 1 + len(local)
+	if len(sync.toDelete) > 1 &&
+		deleteAllCalls < len(sync.toDelete)+len(sync.toUpload) {
+		sync.deleteAll = true
+		sync.toDelete = nil
+		sync.toUpload = local
+	}
+	return sync
+}
+
+// updateImages checks for image updates.  For FormatFastlane the imagesDir is
+// the fastlane metadata directory.  The Play Store images end up in the same
+// order as the local ones.  The deletes and uploads are returned, keyed by
+// SHA1.  If dryRun is set they are not made.  The current images of each
+// type are listed parallel at a time.  If unlisted is set the listing is not
+// made yet, in a dry run, so it has no images.  For FormatFastlane a locale
+// without a metadata directory is left as it is.
+func updateImages(
+	ctx context.Context, pub Publisher, w io.Writer, editId,
+	packageName, imagesDir, format,
+	defBcp47, bcp47 string, unlisted bool,
+	parallel int, dryRun bool) ([]Action, error) {
+
+	if format == FormatFastlane && !hasFastlaneLocale(imagesDir, bcp47) {
+		fmt.Fprintf(w, "not changing %s images\n", bcp47)
+		return nil, nil
+	}
+	current := make([][]*ap.Image, len(GooglePlayImageTypes))
+	err := forEach(len(GooglePlayImageTypes), parallel, func(i int) error {
+		if unlisted {
+			// No listing, so no images, yet.
+			return nil
+		}
+		imageType := GooglePlayImageTypes[i]
+		images, err := pub.ListImages(
+			ctx, packageName, editId, bcp47, imageType)
+		if err != nil {
+			return fmt.Errorf("image list for %s %s got %v",
+				bcp47, imageType, err)
+		}
+		current[i] = images
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	needsCommit := false
+	var actions []Action
 	// Go through shots.
-	for _, imageType := range GooglePlayImageTypes {
-		locImageDir := filepath.Join(imagesDir, imageType)
-		// Look for locale specific images first.
-		pattern := filepath.Join(locImageDir, bcp47+"*.png")
-		// Glob only has errors for bad patterns.
-		matches, _ := filepath.Glob(pattern)
-		isLocale := false
-		if len(matches) == 0 {
-			// Look for language specific images.
-			pattern = filepath.Join(locImageDir, iso639+"*.png")
-			matches, _ = filepath.Glob(pattern)
-		} else {
-			isLocale = true
+	for i, imageType := range GooglePlayImageTypes {
+		matches, upload, err := localImageFiles(
+			imagesDir, format, defBcp47, bcp47, imageType)
+		if err != nil {
+			return nil, err
 		}
 		// Get info from the directory and from Google.
 		sis, err := getLocalImagesInfo(matches)
 		if err != nil {
-			return false, err
+			return nil, err
 		}
-		ilr, err := service.Edits.Images.List(
-			packageName, editId, bcp47, imageType).Do()
-		if err != nil {
-			return false, fmt.Errorf("image list for %s %s got %v",
-				bcp47, imageType, err)
+		sync := planImageSync(current[i], sis, upload)
+		if sync.reordered {
+			actions = append(actions, Action{
+				Kind:      ActionReorderImages,
+				Language:  bcp47,
+				ImageType: imageType,
+			})
+			if !dryRun {
+				fmt.Fprintf(w, "reorder %s %s\n", bcp47, imageType)
+			}
 		}
-
-		// Match up the info.
-		var toDelete []*ap.Image
-		for _, image := range ilr.Images {
-			found := false
-			for sii, si := range sis {
-				if si.sha1 == image.Sha1 {
-					sis[sii].image = image
-					found = true
-					break
+		// Delete unwanted, or out of order, images.
+		if sync.deleteAll {
+			actions = append(actions, Action{
+				Kind:      ActionDeleteAllImages,
+				Language:  bcp47,
+				ImageType: imageType,
+			})
+			if !dryRun {
+				fmt.Fprintf(w, "delete all %s %s\n", bcp47, imageType)
+				_, err := pub.DeleteAllImages(
+					ctx, packageName, editId, bcp47, imageType)
+				if err != nil {
+					return nil, err
 				}
 			}
-			if !found {
-				toDelete = append(toDelete, image)
-			}
 		}
-		// Delete unwanted images.
-		for _, doomed := range toDelete {
-			fmt.Printf("delete %s %s %s\n", bcp47, imageType, doomed.Id)
-			err := service.Edits.Images.Delete(
-				packageName, editId, bcp47, imageType, doomed.Id).Do()
+		for _, doomed := range sync.toDelete {
+			actions = append(actions, Action{
+				Kind:      ActionDeleteImage,
+				Language:  bcp47,
+				ImageType: imageType,
+				ImageId:   doomed.Id,
+				Sha1:      doomed.Sha1,
+			})
+			if dryRun {
+				continue
+			}
+			fmt.Fprintf(w, "delete %s %s %s\n", bcp47, imageType, doomed.Id)
+			err := pub.DeleteImage(
+				ctx, packageName, editId, bcp47, imageType, doomed.Id)
 			if err != nil {
-				return false, err
+				return nil, err
 			}
-			needsCommit = true
-		}
-		if !(isDifferentLang || isLocale || isDefLocale) {
-			continue
 		}
-		// Upload new images.
-		for _, si := range sis {
-			if si.image != nil {
-				// We have this image already.
+		// Upload new images, in order, after the ones kept.
+		for _, si := range sync.toUpload {
+			actions = append(actions, Action{
+				Kind:      ActionUploadImage,
+				Language:  bcp47,
+				ImageType: imageType,
+				File:      si.file,
+				Sha1:      si.sha1,
+			})
+			if dryRun {
 				continue
 			}
-			// Update.
-			fmt.Printf("upload %s\n", si.file)
-			fPng, err := os.Open(si.file)
+			fmt.Fprintf(w, "upload %s\n", si.file)
+			_, err := pub.UploadImage(
+				ctx, packageName, editId, bcp47, imageType, si.file)
 			if err != nil {
-				return false, fmt.Errorf("can't open %s got %v", si.file, err)
+				return nil, fmt.Errorf("uploading %s got %v", si.file, err)
 			}
-			defer fPng.Close()
-			_, err = service.Edits.Images.Upload(
-				packageName, editId, bcp47, imageType).Media(fPng).Do()
+		}
+	}
+	if len(actions) == 0 {
+		fmt.Fprintf(w, "no images changes for %s\n", bcp47)
+	}
+	return actions, nil
+}
+
+// localImageFiles returns the image files for a type in a locale in upload
+// order.  For FormatWords these are the files named for the locale, or if
+// there are none, for its language.  upload is false if the files are the
+// default language ones, which the Play Store shows anyway, so they
+// shouldn't be uploaded for the locale.
+func localImageFiles(
+	imagesDir, format, defBcp47, bcp47, imageType string) (
+	files []string, upload bool, err error) {
+
+	if format == FormatFastlane {
+		// Fastlane images are always for the locale.
+		return fastlaneImageFiles(imagesDir, bcp47, imageType), true, nil
+	}
+	defIso639 := xlns.Iso639FromBcp47(defBcp47) // en-US -> en
+	iso639 := xlns.Iso639FromBcp47(bcp47)       // en-GB -> en
+	isDefLocale := defBcp47 == bcp47            // en-US and en-US
+	isDifferentLang := iso639 != defIso639
+
+	byLocale, err := readImageDir(filepath.Join(imagesDir, imageType))
+	if err != nil {
+		return nil, false, err
+	}
+	// Look for locale specific images first.
+	upload = true
+	imageFiles := byLocale[bcp47]
+	if len(imageFiles) == 0 {
+		// Look for language specific images.
+		imageFiles = byLocale[iso639]
+		upload = isDifferentLang || isDefLocale
+	}
+	for _, file := range imageFiles {
+		files = append(files, file.Path)
+	}
+	return files, upload, nil
+}
+
+// checkLocalImages checks the image files an update would upload for the
+// locales against the GooglePlayImageSpecs.  All the problems are returned
+// as ImageProblems.
+func checkLocalImages(
+	imagesDir, format, defBcp47 string, locales []string) error {
+
+	var problems ImageProblems
+	// Language images are shared by locales, only check them once.
+	checked := make(map[string]bool)
+	for _, bcp47 := range locales {
+		for _, imageType := range GooglePlayImageTypes {
+			files, upload, err := localImageFiles(
+				imagesDir, format, defBcp47, bcp47, imageType)
 			if err != nil {
-				return false, fmt.Errorf("uploading %s got %v", si.file, err)
+				return err
+			}
+			if !upload {
+				continue
+			}
+			problems = append(problems,
+				validateImageCount(bcp47, imageType, len(files))...)
+			for _, file := range files {
+				if checked[file] {
+					continue
+				}
+				checked[file] = true
+				problems = append(problems, ValidateImage(imageType, file)...)
 			}
-			needsCommit = true
 		}
 	}
-	if !needsCommit {
-		fmt.Printf("no images changes for %s\n", bcp47)
+	if len(problems) != 0 {
+		return problems
 	}
-	return needsCommit, nil
+	return nil
 }
 
 func getLocalImagesInfo(files []string) ([]shotInfo, error) {
@@ -455,7 +866,7 @@ func getLocalImagesInfo(files []string) ([]shotInfo, error) {
 		if err != nil {
 			return nil, fmt.Errorf("SHA1 for %s got %v", file, err)
 		}
-		sis[i] = shotInfo{file: file, sha1: sha1, image: nil}
+		sis[i] = shotInfo{file: file, sha1: sha1}
 	}
 	return sis, nil
 }
@@ -0,0 +1,203 @@
+// package_test.go
+// Tests PackageInfo against the fakeplay server and planning how to sync the
+// Play Store images with the local ones.
+package androidpub
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/napcatstudio/androidpubtools/androidpub/fakeplay"
+	ap "google.golang.org/api/androidpublisher/v3"
+)
+
+func TestPackageInfo(t *testing.T) {
+	server, pub := newTestServer(t, &fakeplay.App{
+		Details: ap.AppDetails{
+			DefaultLanguage: "en-US",
+			ContactEmail:    "support@example.com",
+		},
+		Listings: map[string]*ap.Listing{
+			"en-US": {Language: "en-US", Title: "Example"},
+			"en-GB": {Language: "en-GB", Title: "Example"},
+			"de-DE": {Language: "de-DE", Title: "Beispiel"},
+		},
+		Bundles: []*ap.Bundle{{VersionCode: 7}},
+		Tracks: map[string]*ap.Track{
+			"production": {Track: "production", Releases: []*ap.TrackRelease{
+				{Status: "completed", VersionCodes: []int64{7}},
+			}},
+		},
+	})
+	for i, image := range []struct{ language, imageType string }{
+		{"en-US", "phoneScreenshots"},
+		{"en-US", "phoneScreenshots"},
+		{"en-US", "icon"},
+		{"de-DE", "phoneScreenshots"},
+	} {
+		server.AddImage(testPackage, image.language, image.imageType,
+			[]byte{byte(i)})
+	}
+	type coverage struct {
+		fallback, belowMinimum []string
+	}
+	// Every locale is short of a feature graphic.  de-DE has its own phone
+	// screenshot, but too few, and shows the en-US icon.  en-GB shows the
+	// en-US images.
+	allCoverage := map[string]coverage{
+		"en-US": {nil, []string{"featureGraphic"}},
+		"en-GB": {[]string{"phoneScreenshots", "icon"},
+			[]string{"featureGraphic"}},
+		"de-DE": {[]string{"icon"},
+			[]string{"phoneScreenshots", "featureGraphic"}},
+	}
+	imageCounts := map[string]int{
+		"en-US phoneScreenshots": 2,
+		"en-US icon":             1,
+		"de-DE phoneScreenshots": 1,
+	}
+	tests := []struct {
+		name  string
+		langs []string
+		want  []string // The locales looked at.
+	}{
+		{"all", nil, []string{"de-DE", "en-GB", "en-US"}},
+		{"not the default language", []string{"de-DE"}, []string{"de-DE"}},
+		{"default language", []string{"en-US"}, []string{"en-US"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info, err := PackageInfo(
+				context.Background(), pub, testPackage, test.langs, 2)
+			if err != nil {
+				t.Fatalf("PackageInfo got %v", err)
+			}
+			if info.Details.ContactEmail != "support@example.com" {
+				t.Errorf("details %+v", info.Details)
+			}
+			tracks := make(map[string]*ap.Track)
+			for _, track := range info.Tracks {
+				tracks[track.Track] = track
+			}
+			production := tracks["production"]
+			if production == nil || len(production.Releases) != 1 ||
+				production.Releases[0].VersionCodes[0] != 7 {
+				t.Errorf("production track %+v, want version 7", production)
+			}
+
+			locales := make(map[string]bool)
+			for _, listing := range info.Listings {
+				locales[listing.Language] = true
+			}
+			if len(locales) != len(test.want) {
+				t.Errorf("listings %v, want %v", locales, test.want)
+			}
+			if got, want := len(info.Images),
+				len(test.want)*len(GooglePlayImageTypes); got != want {
+				t.Errorf("%d image sets, want %d", got, want)
+			}
+			for _, set := range info.Images {
+				if !locales[set.Language] {
+					t.Errorf("%s images not asked for", set.Language)
+				}
+				key := set.Language + " " + set.ImageType
+				if len(set.Images) != imageCounts[key] {
+					t.Errorf("%d %s images, want %d",
+						len(set.Images), key, imageCounts[key])
+				}
+			}
+			if len(info.Coverage) != len(test.want) {
+				t.Errorf("coverage %+v, want %v", info.Coverage, test.want)
+			}
+			for _, c := range info.Coverage {
+				want, ok := allCoverage[c.Language]
+				if !ok || !locales[c.Language] {
+					t.Errorf("coverage for %s not asked for", c.Language)
+					continue
+				}
+				if !reflect.DeepEqual(c.Fallback, want.fallback) ||
+					!reflect.DeepEqual(c.BelowMinimum, want.belowMinimum) {
+					t.Errorf("%s fallback %v below minimum %v, want %v %v",
+						c.Language, c.Fallback, c.BelowMinimum,
+						want.fallback, want.belowMinimum)
+				}
+			}
+			if got := server.OpenEdits(); got != 0 {
+				t.Errorf("%d open edits, want 0", got)
+			}
+		})
+	}
+}
+
+func TestPlanImageSync(t *testing.T) {
+	tests := []struct {
+		name    string
+		current string // SHA1s of the current images, one letter each.
+		local   string // SHA1s of the local images.
+		upload  bool
+		want    imageSync
+		// toDelete and toUpload are the SHA1s in want.
+		toDelete, toUpload string
+	}{
+		{name: "same", current: "abc", local: "abc", upload: true},
+		{name: "add", current: "", local: "ab", upload: true,
+			toUpload: "ab"},
+		{name: "append", current: "ab", local: "abc", upload: true,
+			toUpload: "c"},
+		{name: "keep start", current: "abc", local: "ab", upload: true,
+			toDelete: "c"},
+		{name: "delete middle", current: "abc", local: "ac", upload: true,
+			toDelete: "b"},
+		{name: "replace end", current: "abc", local: "abd", upload: true,
+			toDelete: "c", toUpload: "d"},
+		{name: "swap", current: "abc", local: "acb", upload: true,
+			want: imageSync{reordered: true}, toDelete: "b", toUpload: "b"},
+		{name: "reverse", current: "abc", local: "cba", upload: true,
+			want: imageSync{reordered: true}, toDelete: "ab", toUpload: "ba"},
+		{name: "rotate", current: "abcd", local: "dabc", upload: true,
+			want:     imageSync{deleteAll: true, reordered: true},
+			toUpload: "dabc"},
+		{name: "delete all", current: "abc", local: "", upload: true,
+			want: imageSync{deleteAll: true}},
+		{name: "delete one of one", current: "a", local: "", upload: true,
+			toDelete: "a"},
+		{name: "replace all", current: "abc", local: "def", upload: true,
+			want: imageSync{deleteAll: true}, toUpload: "def"},
+		{name: "not uploaded keeps wanted", current: "abc", local: "ca",
+			toDelete: "b"},
+		{name: "not uploaded any order", current: "abc", local: "cba"},
+		{name: "not uploaded adds nothing", current: "", local: "ab"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var current []*ap.Image
+			for i, sha1 := range test.current {
+				current = append(current, &ap.Image{
+					Id: string(rune('1' + i)), Sha1: string(sha1)})
+			}
+			var local []shotInfo
+			for _, sha1 := range test.local {
+				local = append(local, shotInfo{
+					file: string(sha1) + ".png", sha1: string(sha1)})
+			}
+			got := planImageSync(current, local, test.upload)
+
+			var toDelete, toUpload string
+			for _, image := range got.toDelete {
+				toDelete += image.Sha1
+			}
+			for _, si := range got.toUpload {
+				toUpload += si.sha1
+			}
+			if toDelete != test.toDelete || toUpload != test.toUpload {
+				t.Errorf("planImageSync deletes %q uploads %q, want %q %q",
+					toDelete, toUpload, test.toDelete, test.toUpload)
+			}
+			got.toDelete, got.toUpload = nil, nil
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("planImageSync got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+// parallel.go
+// Contains helpers for doing work, like updating each locale, a few at a
+// time while keeping the output in order.
+package androidpub
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Errors is an error made from several errors, such as one for each
+// locale that failed.
+type Errors []error
+
+func (es Errors) Error() string {
+	if len(es) == 1 {
+		return es[0].Error()
+	}
+	lines := make([]string, len(es))
+	for i, err := range es {
+		lines[i] = err.Error()
+	}
+	return fmt.Sprintf("%d errors:\n%s", len(es), strings.Join(lines, "\n"))
+}
+
+// forEach calls fn for 0 to n-1 with up to parallel calls running at once.
+// All the calls are made even if some fail.  The errors are returned, in
+// order, as Errors.
+func forEach(n, parallel int, fn func(i int) error) error {
+	errs := make([]error, n)
+	if parallel < 1 {
+		parallel = 1
+	}
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() { <-sem; wg.Done() }()
+			errs[i] = fn(i)
+		}(i)
+	}
+	wg.Wait()
+	var failed Errors
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	if len(failed) != 0 {
+		return failed
+	}
+	return nil
+}
+
+// forEachOrdered is forEach where each call writes its output to its own
+// buffer.  The buffers are written to out in order, each as soon as it and
+// all the ones before it are done, so the output is the same as doing the
+// calls one at a time.
+func forEachOrdered(
+	out io.Writer, n, parallel int,
+	fn func(i int, w io.Writer) error) error {
+
+	bufs := make([]bytes.Buffer, n)
+	done := make([]chan struct{}, n)
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+	printed := make(chan struct{})
+	go func() {
+		defer close(printed)
+		for i := range bufs {
+			<-done[i]
+			out.Write(bufs[i].Bytes())
+		}
+	}()
+	err := forEach(n, parallel, func(i int) error {
+		defer close(done[i])
+		return fn(i, &bufs[i])
+	})
+	<-printed
+	return err
+}
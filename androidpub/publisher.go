@@ -0,0 +1,290 @@
+// publisher.go
+// Contains the Publisher interface the package functions use to talk to the
+// Play Store and its implementation using the Google Play Developer API.
+// Other implementations can record, replay, cache or fake the calls.
+package androidpub
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	ap "google.golang.org/api/androidpublisher/v3"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+// Publisher is the part of the Play Store edits API used by this package.
+// Uploads are given a file name, not a reader, so that an implementation can
+// open the file again to retry.
+type Publisher interface {
+	// Edits.
+	InsertEdit(ctx context.Context, packageName string) (*ap.AppEdit, error)
+	GetEdit(ctx context.Context, packageName, editId string) (*ap.AppEdit, error)
+	ValidateEdit(
+		ctx context.Context, packageName, editId string) (*ap.AppEdit, error)
+	// CommitEdit commits the edit.  If notSentForReview is set the changes
+	// have to be sent for review in the Play Console.
+	CommitEdit(
+		ctx context.Context, packageName, editId string,
+		notSentForReview bool) (*ap.AppEdit, error)
+	DeleteEdit(ctx context.Context, packageName, editId string) error
+
+	// App details.
+	GetDetails(
+		ctx context.Context, packageName, editId string) (*ap.AppDetails, error)
+	UpdateDetails(
+		ctx context.Context, packageName, editId string,
+		details *ap.AppDetails) (*ap.AppDetails, error)
+
+	// Store listings.
+	ListListings(
+		ctx context.Context, packageName, editId string) ([]*ap.Listing, error)
+	GetListing(
+		ctx context.Context,
+		packageName, editId, language string) (*ap.Listing, error)
+	UpdateListing(
+		ctx context.Context, packageName, editId, language string,
+		listing *ap.Listing) (*ap.Listing, error)
+
+	// Listing images.
+	ListImages(
+		ctx context.Context,
+		packageName, editId, language, imageType string) ([]*ap.Image, error)
+	UploadImage(
+		ctx context.Context,
+		packageName, editId, language, imageType, file string) (*ap.Image, error)
+	DeleteImage(
+		ctx context.Context,
+		packageName, editId, language, imageType, imageId string) error
+	DeleteAllImages(
+		ctx context.Context,
+		packageName, editId, language, imageType string) ([]*ap.Image, error)
+
+	// Tracks.
+	ListTracks(
+		ctx context.Context, packageName, editId string) ([]*ap.Track, error)
+	GetTrack(
+		ctx context.Context,
+		packageName, editId, track string) (*ap.Track, error)
+	UpdateTrack(
+		ctx context.Context, packageName, editId, track string,
+		update *ap.Track) (*ap.Track, error)
+
+	// Bundles and APKs.
+	UploadBundle(
+		ctx context.Context, packageName, editId, file string) (*ap.Bundle, error)
+	UploadApk(
+		ctx context.Context, packageName, editId, file string) (*ap.Apk, error)
+}
+
+// GetPublisher reads the service credentials from the JSON file and creates
+// a Publisher using the Google Play Developer API.  The client keeps ctx for
+// refreshing its token, so it shouldn't be cancelled while the Publisher is
+// in use, the calls take their own contexts.
+func GetPublisher(
+	ctx context.Context,
+	credentialsJson string, opts ...option.ClientOption) (Publisher, error) {
+
+	service, err := GetAPService(ctx, credentialsJson, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return NewGooglePublisher(service), nil
+}
+
+// NewGooglePublisher returns a Publisher using the Android Publisher
+// service.
+func NewGooglePublisher(service *ap.Service) Publisher {
+	return &googlePublisher{service: service}
+}
+
+// googlePublisher is a Publisher using the Google Play Developer API.
+type googlePublisher struct {
+	service *ap.Service
+}
+
+func (p *googlePublisher) InsertEdit(
+	ctx context.Context, packageName string) (*ap.AppEdit, error) {
+
+	return p.service.Edits.Insert(packageName, nil).Context(ctx).Do()
+}
+
+func (p *googlePublisher) GetEdit(
+	ctx context.Context,
+	packageName, editId string) (*ap.AppEdit, error) {
+
+	return p.service.Edits.Get(packageName, editId).Context(ctx).Do()
+}
+
+func (p *googlePublisher) ValidateEdit(
+	ctx context.Context,
+	packageName, editId string) (*ap.AppEdit, error) {
+
+	return p.service.Edits.Validate(packageName, editId).Context(ctx).Do()
+}
+
+func (p *googlePublisher) CommitEdit(
+	ctx context.Context,
+	packageName, editId string, notSentForReview bool) (*ap.AppEdit, error) {
+
+	call := p.service.Edits.Commit(packageName, editId)
+	if notSentForReview {
+		call.ChangesNotSentForReview(true)
+	}
+	return call.Context(ctx).Do()
+}
+
+func (p *googlePublisher) DeleteEdit(
+	ctx context.Context, packageName, editId string) error {
+
+	return p.service.Edits.Delete(packageName, editId).Context(ctx).Do()
+}
+
+func (p *googlePublisher) GetDetails(
+	ctx context.Context,
+	packageName, editId string) (*ap.AppDetails, error) {
+
+	return p.service.Edits.Details.Get(packageName, editId).Context(ctx).Do()
+}
+
+func (p *googlePublisher) UpdateDetails(
+	ctx context.Context,
+	packageName, editId string,
+	details *ap.AppDetails) (*ap.AppDetails, error) {
+
+	return p.service.Edits.Details.Update(
+		packageName, editId, details).Context(ctx).Do()
+}
+
+func (p *googlePublisher) ListListings(
+	ctx context.Context,
+	packageName, editId string) ([]*ap.Listing, error) {
+
+	llr, err := p.service.Edits.Listings.List(
+		packageName, editId).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return llr.Listings, nil
+}
+
+func (p *googlePublisher) GetListing(
+	ctx context.Context,
+	packageName, editId, language string) (*ap.Listing, error) {
+
+	return p.service.Edits.Listings.Get(
+		packageName, editId, language).Context(ctx).Do()
+}
+
+func (p *googlePublisher) UpdateListing(
+	ctx context.Context,
+	packageName, editId, language string,
+	listing *ap.Listing) (*ap.Listing, error) {
+
+	return p.service.Edits.Listings.Update(
+		packageName, editId, language, listing).Context(ctx).Do()
+}
+
+func (p *googlePublisher) ListImages(
+	ctx context.Context,
+	packageName, editId, language, imageType string) ([]*ap.Image, error) {
+
+	ilr, err := p.service.Edits.Images.List(
+		packageName, editId, language, imageType).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return ilr.Images, nil
+}
+
+func (p *googlePublisher) UploadImage(
+	ctx context.Context,
+	packageName, editId, language, imageType, file string) (*ap.Image, error) {
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("can't open %s got %v", file, err)
+	}
+	defer f.Close()
+	iur, err := p.service.Edits.Images.Upload(
+		packageName, editId, language, imageType).Media(f).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return iur.Image, nil
+}
+
+func (p *googlePublisher) DeleteImage(
+	ctx context.Context,
+	packageName, editId, language, imageType, imageId string) error {
+
+	return p.service.Edits.Images.Delete(
+		packageName, editId, language, imageType, imageId).Context(ctx).Do()
+}
+
+func (p *googlePublisher) DeleteAllImages(
+	ctx context.Context,
+	packageName, editId, language, imageType string) ([]*ap.Image, error) {
+
+	idr, err := p.service.Edits.Images.Deleteall(
+		packageName, editId, language, imageType).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return idr.Deleted, nil
+}
+
+func (p *googlePublisher) ListTracks(
+	ctx context.Context,
+	packageName, editId string) ([]*ap.Track, error) {
+
+	tlr, err := p.service.Edits.Tracks.List(packageName, editId).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return tlr.Tracks, nil
+}
+
+func (p *googlePublisher) GetTrack(
+	ctx context.Context,
+	packageName, editId, track string) (*ap.Track, error) {
+
+	return p.service.Edits.Tracks.Get(packageName, editId, track).Context(ctx).Do()
+}
+
+func (p *googlePublisher) UpdateTrack(
+	ctx context.Context,
+	packageName, editId, track string,
+	update *ap.Track) (*ap.Track, error) {
+
+	return p.service.Edits.Tracks.Update(
+		packageName, editId, track, update).Context(ctx).Do()
+}
+
+func (p *googlePublisher) UploadBundle(
+	ctx context.Context,
+	packageName, editId, file string) (*ap.Bundle, error) {
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("can't open %s got %v", file, err)
+	}
+	defer f.Close()
+	return p.service.Edits.Bundles.Upload(packageName, editId).Media(
+		f, googleapi.ContentType("application/octet-stream")).Context(ctx).Do()
+}
+
+func (p *googlePublisher) UploadApk(
+	ctx context.Context,
+	packageName, editId, file string) (*ap.Apk, error) {
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("can't open %s got %v", file, err)
+	}
+	defer f.Close()
+	return p.service.Edits.Apks.Upload(packageName, editId).Media(
+		f, googleapi.ContentType("application/vnd.android.package-archive")).
+		Context(ctx).Do()
+}
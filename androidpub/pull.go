@@ -0,0 +1,254 @@
+// pull.go
+// Contains functions for exporting the live Play Store listings and images
+// into local files.
+package androidpub
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	ap "google.golang.org/api/androidpublisher/v3"
+)
+
+const (
+	// detailsFile is the name of the app details file in a listings
+	// directory.
+	detailsFile = "details.json"
+	// listingExt is the extension of a listing file in a listings directory.
+	listingExt = ".json"
+)
+
+// PackagePull downloads the package listings into listingsDir and images
+// into imagesDir.  Each listing is written as BCP47.json and the app
+// details as details.json.  Images are written as TYPE/BCP47_#.png, or .jpg
+// for JPEG images.  For
+// FormatFastlane listingsDir is the fastlane metadata directory, which has
+// the images too, and imagesDir is not used.  Images whose SHA1 already
+// matches the local file are skipped.  Local images that are not live are
+// listed, or if prune is set deleted.  If langs is given only those locales
+// are pulled.  Progress is written to w.
+func PackagePull(
+	ctx context.Context, pub Publisher, w io.Writer,
+	packageName, format, listingsDir, imagesDir string,
+	langs []string, prune bool) error {
+
+	session, err := NewEditSession(ctx, pub, packageName)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	editId := session.Id
+
+	appDetails, err := pub.GetDetails(ctx, packageName, editId)
+	if err != nil {
+		return fmt.Errorf("getting %s details got %v", packageName, err)
+	}
+	if err := os.MkdirAll(listingsDir, 0755); err != nil {
+		return err
+	}
+	if format != FormatFastlane {
+		err = writeJson(filepath.Join(listingsDir, detailsFile), appDetails)
+		if err != nil {
+			return err
+		}
+	}
+
+	listings, err := listings(ctx, pub, packageName, editId, langs)
+	if err != nil {
+		return err
+	}
+	for i, listing := range listings {
+		fmt.Fprintf(w, "%s (%d/%d)\n", listing.Language, i+1, len(listings))
+		bcp47 := listing.Language
+		imagePath := func(imageType string, n int) string {
+			return filepath.Join(
+				imagesDir, imageType, fmt.Sprintf("%s_%d", bcp47, n))
+		}
+		localFiles := func(imageType string) []string {
+			return pulledImageFiles(filepath.Join(imagesDir, imageType), bcp47)
+		}
+		if format == FormatFastlane {
+			err = writeFastlaneListing(listingsDir, listing)
+			imagePath = func(imageType string, n int) string {
+				return fastlaneImagePath(listingsDir, bcp47, imageType, n)
+			}
+			localFiles = func(imageType string) []string {
+				return fastlaneImageFiles(listingsDir, bcp47, imageType)
+			}
+		} else {
+			err = writeJson(
+				filepath.Join(listingsDir, bcp47+listingExt), listing)
+		}
+		if err != nil {
+			return err
+		}
+		err = pullImages(ctx, pub, w, editId, packageName, bcp47,
+			imagePath, localFiles, prune)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Nothing was changed.
+	return session.Delete(ctx)
+}
+
+// pullImages downloads all the images for a locale.  The imagePath
+// function gives the file, without the extension, for the n'th image of a
+// type and localFiles the files of a type there are now.  The extension
+// comes from the image format.  Local files that are not one of the live
+// images, like ones numbered past the live ones or new ones not uploaded
+// yet, are listed.  If prune is set they are deleted so the local images end
+// up the same as the live ones.
+func pullImages(
+	ctx context.Context, pub Publisher, w io.Writer,
+	editId, packageName, bcp47 string,
+	imagePath func(imageType string, n int) string,
+	localFiles func(imageType string) []string, prune bool) error {
+
+	for _, imageType := range GooglePlayImageTypes {
+		images, err := pub.ListImages(
+			ctx, packageName, editId, bcp47, imageType)
+		if err != nil {
+			return fmt.Errorf("image list for %s %s got %v",
+				bcp47, imageType, err)
+		}
+		local := localFiles(imageType)
+		live := make(map[string]bool)
+		for n, image := range images {
+			base := imagePath(imageType, n)
+			if err := os.MkdirAll(filepath.Dir(base), 0755); err != nil {
+				return err
+			}
+			file := sameImageFile(local, base, image.Sha1)
+			if file == "" {
+				file, err = downloadImage(ctx, w, image, base)
+				if err != nil {
+					return err
+				}
+			}
+			live[file] = true
+		}
+		for _, file := range local {
+			if live[file] {
+				continue
+			}
+			if !prune {
+				fmt.Fprintf(w, "not live %s\n", file)
+				continue
+			}
+			fmt.Fprintf(w, "delete %s\n", file)
+			if err := os.Remove(file); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sameImageFile returns the file in files that is base with an extension
+// and has the SHA1, or "" if there isn't one.
+func sameImageFile(files []string, base, sha1 string) string {
+	for _, file := range files {
+		if strings.TrimSuffix(file, filepath.Ext(file)) != base {
+			continue
+		}
+		if have, err := fileSha1(file); err == nil && have == sha1 {
+			return file
+		}
+	}
+	return ""
+}
+
+// pulledImageFiles returns the image files in dir, named for the locale
+// using the images directory naming convention.
+func pulledImageFiles(dir, bcp47 string) []string {
+	// Glob only has errors for bad patterns.
+	matches, _ := filepath.Glob(filepath.Join(dir, "*"))
+	var files []string
+	for _, match := range matches {
+		image, err := ParseImageFileName(match)
+		if err != nil || image.Locale != bcp47 {
+			continue
+		}
+		files = append(files, match)
+	}
+	return files
+}
+
+// downloadImage writes the image to base with the extension for its
+// format and returns the file name.
+func downloadImage(
+	ctx context.Context, w io.Writer,
+	image *ap.Image, base string) (string, error) {
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, image.Url, nil)
+	if err != nil {
+		return "", fmt.Errorf("downloading %s got %v", image.Url, err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("downloading %s got %v", image.Url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("downloading %s got %s", image.Url, resp.Status)
+	}
+	bytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("downloading %s got %v", image.Url, err)
+	}
+	file := base + imageExt(bytes)
+	fmt.Fprintf(w, "download %s\n", file)
+	if sha1 := fmt.Sprintf("%x", sha1.Sum(bytes)); sha1 != image.Sha1 {
+		fmt.Fprintf(os.Stderr,
+			"warning: %s SHA1 %s differs from Play Store %s\n",
+			file, sha1, image.Sha1)
+	}
+	if err := ioutil.WriteFile(file, bytes, 0644); err != nil {
+		return "", fmt.Errorf("writing %s got %v", file, err)
+	}
+	return file, nil
+}
+
+// imageExt returns the file extension for the image data, .jpg for JPEG
+// and otherwise .png.
+func imageExt(data []byte) string {
+	if http.DetectContentType(data) == "image/jpeg" {
+		return ".jpg"
+	}
+	return ".png"
+}
+
+// writeJson writes v as indented JSON to file.
+func writeJson(file string, v interface{}) error {
+	bytes, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding %s got %v", file, err)
+	}
+	bytes = append(bytes, '\n')
+	if err := ioutil.WriteFile(file, bytes, 0644); err != nil {
+		return fmt.Errorf("writing %s got %v", file, err)
+	}
+	return nil
+}
+
+// readJson reads JSON from file into v.
+func readJson(file string, v interface{}) error {
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("reading %s got %v", file, err)
+	}
+	if err := json.Unmarshal(bytes, v); err != nil {
+		return fmt.Errorf("decoding %s got %v", file, err)
+	}
+	return nil
+}
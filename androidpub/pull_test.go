@@ -0,0 +1,68 @@
+// pull_test.go
+// Tests pulling the live listings and images against the fakeplay server.
+package androidpub
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPackagePull(t *testing.T) {
+	for _, prune := range []bool{false, true} {
+		name := "list"
+		if prune {
+			name = "prune"
+		}
+		t.Run(name, func(t *testing.T) {
+			server, pub := newTestServer(t, nil)
+			live := []byte("live screenshot")
+			server.AddImage(testPackage, "en-US", "phoneScreenshots", live)
+			listingsDir, imagesDir := t.TempDir(), t.TempDir()
+			screenshots := filepath.Join(imagesDir, "phoneScreenshots")
+			// An old image 0 and a new image 1 that isn't uploaded yet.
+			makeFiles(t, imagesDir, "phoneScreenshots/")
+			old := filepath.Join(screenshots, "en-US_0.png")
+			added := filepath.Join(screenshots, "en-US_1.png")
+			for _, file := range []string{old, added} {
+				err := ioutil.WriteFile(file, []byte(file), 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var progress bytes.Buffer
+			err := PackagePull(context.Background(), pub, &progress,
+				testPackage, FormatWords, listingsDir, imagesDir, nil, prune)
+			if err != nil {
+				t.Fatalf("PackagePull got %v", err)
+			}
+			data, err := ioutil.ReadFile(old)
+			if err != nil || !bytes.Equal(data, live) {
+				t.Errorf("%s got %q %v, want the live image", old, data, err)
+			}
+			_, err = os.Stat(added)
+			if prune && !os.IsNotExist(err) {
+				t.Errorf("pruned %s still there", added)
+			}
+			if !prune && err != nil {
+				t.Errorf("%s not kept got %v", added, err)
+			}
+			if !strings.Contains(progress.String(), added) {
+				t.Errorf("progress %q doesn't list %s",
+					progress.String(), added)
+			}
+			listing := filepath.Join(listingsDir, "en-US"+listingExt)
+			if _, err := os.Stat(listing); err != nil {
+				t.Errorf("listing not pulled got %v", err)
+			}
+			if got := server.OpenEdits(); got != 0 {
+				t.Errorf("%d open edits, want 0", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,476 @@
+// release.go
+// Contains functions for uploading Android App Bundles and APKs and
+// assigning them to tracks.
+package androidpub
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	xlns "github.com/napcatstudio/translate/v2"
+	"github.com/rivo/uniseg"
+
+	ap "google.golang.org/api/androidpublisher/v3"
+)
+
+// maxReleaseNotesLength is the Play limit on release notes per language.
+const maxReleaseNotesLength = 500
+
+var (
+	// GooglePlayTracks are the standard tracks.  Custom (closed testing)
+	// tracks can also be used by name.
+	GooglePlayTracks = []string{
+		"internal",   // Internal testing.
+		"alpha",      // Closed testing.
+		"beta",       // Open testing.
+		"production", // Production.
+	}
+
+	// GooglePlayReleaseStatuses are the statuses a track release can have.
+	GooglePlayReleaseStatuses = []string{
+		"draft",      // Not yet available to users.
+		"inProgress", // Staged rollout to UserFraction of users.
+		"halted",     // Staged rollout stopped.
+		"completed",  // Available to all users on the track.
+	}
+)
+
+// Release describes a release to put on a track.
+type Release struct {
+	// File is an Android App Bundle (.aab) or APK (.apk) to upload.  It can
+	// be empty if VersionCodes is set.
+	File string
+	// VersionCodes are already uploaded version codes to release.  The
+	// version code of File is added to them.
+	VersionCodes []int64
+	// Track is the track name, for instance internal, alpha, beta,
+	// production or a custom track name.
+	Track string
+	// Name is the release name shown in the Play Console.  Optional.
+	Name string
+	// Status is one of GooglePlayReleaseStatuses.
+	Status string
+	// UserFraction is the fraction of users that get a staged rollout.  It
+	// must be between 0 and 1 for inProgress and halted releases and 0
+	// otherwise.
+	UserFraction float64
+	// Notes are the release notes ("What's new") in the default language.
+	// They are translated, using the meaning ordered words files, for each
+	// listing locale.  If there is no File or VersionCodes the notes are
+	// put on the current release of Track.
+	Notes string
+}
+
+// check checks the release settings are consistent.
+func (r *Release) check() error {
+	if r.Track == "" {
+		return fmt.Errorf("release missing track")
+	}
+	if r.notesOnly() {
+		if r.Notes == "" {
+			return fmt.Errorf("release needs a file, version codes or notes")
+		}
+		return nil
+	}
+	if r.File != "" {
+		ext := strings.ToLower(filepath.Ext(r.File))
+		if ext != ".aab" && ext != ".apk" {
+			return fmt.Errorf("release file %s is not .aab or .apk", r.File)
+		}
+	}
+	return checkStatusFraction(r.Status, r.UserFraction)
+}
+
+// notesOnly is true if the release only changes the release notes of the
+// current track release.
+func (r *Release) notesOnly() bool {
+	return r.File == "" && len(r.VersionCodes) == 0
+}
+
+// checkStatusFraction checks a release status and user fraction work
+// together.
+func checkStatusFraction(status string, fraction float64) error {
+	switch status {
+	case "inProgress", "halted":
+		if fraction <= 0 || fraction >= 1 {
+			return fmt.Errorf("%s release needs a user fraction between 0 and 1 not %v",
+				status, fraction)
+		}
+	case "draft", "completed":
+		if fraction != 0 {
+			return fmt.Errorf("%s release can't have a user fraction", status)
+		}
+	default:
+		return fmt.Errorf("bad release status '%s' must be one of %v",
+			status, GooglePlayReleaseStatuses)
+	}
+	return nil
+}
+
+// PackageRelease uploads a bundle or APK, if given, and puts it on a track.
+// Release notes are translated using wordsDir for the listing locales, or
+// only langs if given.  If notSentForReview is set the release has to be
+// sent for review from the Play Console.
+func PackageRelease(
+	ctx context.Context, pub Publisher, packageName, wordsDir string,
+	langs []string,
+	release Release, dryRun, notSentForReview bool) (*UpdateResult, error) {
+
+	return PackageUpdate(ctx, pub, packageName, UpdateOptions{
+		WordsDir:         wordsDir,
+		Langs:            langs,
+		DryRun:           dryRun,
+		Release:          &release,
+		NotSentForReview: notSentForReview,
+	})
+}
+
+// updateRelease uploads the release file, if any, and assigns the release,
+// with the translated notes, to its track in the given edit.  The release
+// must already be checked.  A release of only version codes that the track
+// already has is left alone.  Progress is written to w.
+func updateRelease(
+	ctx context.Context, pub Publisher, w io.Writer, editId, packageName string,
+	release *Release, notes []*ap.LocalizedText, dryRun bool) ([]Action, error) {
+
+	if release.notesOnly() {
+		action, err := updateReleaseNotes(
+			ctx, pub, w, editId, packageName, release.Track, notes, dryRun)
+		if err != nil {
+			return nil, err
+		}
+		return []Action{*action}, nil
+	}
+
+	var actions []Action
+	versionCodes := append([]int64{}, release.VersionCodes...)
+	if release.File != "" {
+		upload := Action{Kind: ActionUploadBinary, File: release.File}
+		if !dryRun {
+			versionCode, err := uploadBinary(
+				ctx, pub, w, editId, packageName, release.File)
+			if err != nil {
+				return nil, err
+			}
+			versionCodes = append(versionCodes, versionCode)
+			upload.VersionCodes = []int64{versionCode}
+		}
+		actions = append(actions, upload)
+	}
+
+	trackRelease := &ap.TrackRelease{
+		Name:         release.Name,
+		Status:       release.Status,
+		UserFraction: release.UserFraction,
+		VersionCodes: versionCodes,
+		ReleaseNotes: notes,
+	}
+	if release.File == "" {
+		// Releasing the same version codes again changes nothing.
+		track, err := pub.GetTrack(ctx, packageName, editId, release.Track)
+		if err != nil {
+			return nil, fmt.Errorf("getting %s track %s got %v",
+				packageName, release.Track, err)
+		}
+		if hasRelease(track, trackRelease) {
+			fmt.Fprintf(w, "%s already has release %v\n",
+				release.Track, versionCodes)
+			return nil, nil
+		}
+	}
+	actions = append(actions, Action{
+		Kind:         ActionRelease,
+		Track:        release.Track,
+		Status:       release.Status,
+		UserFraction: release.UserFraction,
+		VersionCodes: versionCodes,
+		Notes:        notes,
+	})
+	if dryRun {
+		return actions, nil
+	}
+	track, err := pub.GetTrack(ctx, packageName, editId, release.Track)
+	if err != nil {
+		return nil, fmt.Errorf("getting %s track %s got %v",
+			packageName, release.Track, err)
+	}
+	track.Releases = withRelease(track.Releases, trackRelease)
+	_, err = pub.UpdateTrack(ctx, packageName, editId, release.Track, track)
+	if err != nil {
+		return nil, fmt.Errorf("updating %s track %s got %v",
+			packageName, release.Track, err)
+	}
+	fmt.Fprintf(w, "released %v to %s as %s\n",
+		versionCodes, release.Track, release.Status)
+	return actions, nil
+}
+
+// hasRelease is true if the track already has the release.  Names are only
+// compared if the release has one, Play names releases that don't.
+func hasRelease(track *ap.Track, release *ap.TrackRelease) bool {
+	for _, have := range track.Releases {
+		same := &ap.TrackRelease{
+			Name:         have.Name,
+			Status:       have.Status,
+			UserFraction: have.UserFraction,
+			VersionCodes: have.VersionCodes,
+			ReleaseNotes: have.ReleaseNotes,
+		}
+		if release.Name == "" {
+			same.Name = ""
+		}
+		if releasesKey([]*ap.TrackRelease{same}) ==
+			releasesKey([]*ap.TrackRelease{release}) {
+			return true
+		}
+	}
+	return false
+}
+
+// withRelease returns the releases a track should have when adding
+// release.  A staged rollout keeps the completed release it is replacing,
+// anything else replaces all the existing releases.
+func withRelease(
+	existing []*ap.TrackRelease,
+	release *ap.TrackRelease) []*ap.TrackRelease {
+
+	releases := []*ap.TrackRelease{release}
+	if release.Status != "inProgress" && release.Status != "halted" {
+		return releases
+	}
+	for _, old := range existing {
+		if old.Status == "completed" {
+			releases = append(releases, old)
+		}
+	}
+	return releases
+}
+
+// uploadBinary uploads an Android App Bundle or APK and returns its version
+// code.
+func uploadBinary(
+	ctx context.Context, pub Publisher, w io.Writer,
+	editId, packageName, file string) (int64, error) {
+
+	fmt.Fprintf(w, "upload %s\n", file)
+	if strings.ToLower(filepath.Ext(file)) == ".apk" {
+		apk, err := pub.UploadApk(ctx, packageName, editId, file)
+		if err != nil {
+			return 0, fmt.Errorf("uploading %s got %v", file, err)
+		}
+		return apk.VersionCode, nil
+	}
+	bundle, err := pub.UploadBundle(ctx, packageName, editId, file)
+	if err != nil {
+		return 0, fmt.Errorf("uploading %s got %v", file, err)
+	}
+	return bundle.VersionCode, nil
+}
+
+// updateReleaseNotes puts the release notes on the current release of a
+// track.  The current release is the newest one that is not completed, or
+// the completed one if there is only that.
+func updateReleaseNotes(
+	ctx context.Context, pub Publisher, w io.Writer,
+	editId, packageName, trackName string,
+	notes []*ap.LocalizedText, dryRun bool) (*Action, error) {
+
+	track, err := pub.GetTrack(ctx, packageName, editId, trackName)
+	if err != nil {
+		return nil, fmt.Errorf("getting %s track %s got %v",
+			packageName, trackName, err)
+	}
+	current := currentRelease(track)
+	if current == nil {
+		return nil, fmt.Errorf("%s track %s has no release for notes",
+			packageName, trackName)
+	}
+	action := &Action{
+		Kind:         ActionReleaseNotes,
+		Track:        trackName,
+		VersionCodes: current.VersionCodes,
+		Notes:        notes,
+	}
+	if dryRun {
+		return action, nil
+	}
+	current.ReleaseNotes = notes
+	_, err = pub.UpdateTrack(ctx, packageName, editId, trackName, track)
+	if err != nil {
+		return nil, fmt.Errorf("updating %s track %s got %v",
+			packageName, trackName, err)
+	}
+	fmt.Fprintf(w, "set %s release %v notes\n",
+		trackName, current.VersionCodes)
+	return action, nil
+}
+
+// currentRelease returns the release on the track that is being worked on
+// or nil if the track has no releases.
+func currentRelease(track *ap.Track) *ap.TrackRelease {
+	var completed *ap.TrackRelease
+	for _, release := range track.Releases {
+		if release.Status != "completed" {
+			return release
+		}
+		if completed == nil {
+			completed = release
+		}
+	}
+	return completed
+}
+
+// releaseNotes translates the default language release notes into each of
+// the locales.  All the locales are checked against the Play release notes
+// length limit before returning an error.
+func releaseNotes(
+	wordsDir, defBcp47, notes string,
+	locales []string) ([]*ap.LocalizedText, error) {
+
+	baseLang, err := langToUse(wordsDir, defBcp47)
+	if err != nil {
+		return nil, err
+	}
+	texts := []*ap.LocalizedText{{Language: defBcp47, Text: notes}}
+	for _, bcp47 := range locales {
+		if bcp47 == defBcp47 {
+			continue
+		}
+		lang, err := langToUse(wordsDir, bcp47)
+		if err != nil {
+			return nil, err
+		}
+		xm, err := xlns.WordsXlnsMap(wordsDir, baseLang, lang)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s to %s problem got %v",
+				wordsDir, baseLang, lang, err)
+		}
+		texts = append(texts, &ap.LocalizedText{
+			Language: bcp47,
+			Text:     xm.TranslateByLine(notes),
+		})
+	}
+
+	if err := checkNotesLength(texts); err != nil {
+		return nil, err
+	}
+	return texts, nil
+}
+
+// checkNotesLength checks the release notes are no longer than the Play
+// limit.  Characters are counted as they are seen, grapheme clusters, the
+// way the listing fields are.
+func checkNotesLength(texts []*ap.LocalizedText) error {
+	var tooLong []string
+	for _, text := range texts {
+		n := uniseg.GraphemeClusterCount(text.Text)
+		if n > maxReleaseNotesLength {
+			tooLong = append(tooLong, fmt.Sprintf("%s(%d)", text.Language, n))
+		}
+	}
+	if len(tooLong) != 0 {
+		return fmt.Errorf("release notes longer than %d characters for %s",
+			maxReleaseNotesLength, strings.Join(tooLong, " "))
+	}
+	return nil
+}
+
+// PackagePromote copies the release on the fromTrack to the toTrack.  It is
+// the staged rollout, if the fromTrack has one, or the completed release.
+// The version codes, release notes and name are copied.  If fraction is not 0
+// the release is a staged rollout to that fraction of users.  If
+// notSentForReview is set the release has to be sent for review from the
+// Play Console.  Progress is written to w.  The result has the promoted
+// release.
+func PackagePromote(
+	ctx context.Context, pub Publisher, w io.Writer,
+	packageName, fromTrack, toTrack string,
+	fraction float64, dryRun, notSentForReview bool) (*UpdateResult, error) {
+
+	status := "completed"
+	if fraction != 0 {
+		status = "inProgress"
+	}
+	if err := checkStatusFraction(status, fraction); err != nil {
+		return nil, err
+	}
+
+	session, err := NewEditSession(ctx, pub, packageName)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+	editId := session.Id
+	from, err := pub.GetTrack(ctx, packageName, editId, fromTrack)
+	if err != nil {
+		return nil, fmt.Errorf("getting %s track %s got %v",
+			packageName, fromTrack, err)
+	}
+	source := promotableRelease(from)
+	if source == nil {
+		return nil, fmt.Errorf("%s track %s has no release to promote",
+			packageName, fromTrack)
+	}
+	to, err := pub.GetTrack(ctx, packageName, editId, toTrack)
+	if err != nil {
+		return nil, fmt.Errorf("getting %s track %s got %v",
+			packageName, toTrack, err)
+	}
+	promoted := &ap.TrackRelease{
+		Name:         source.Name,
+		Status:       status,
+		UserFraction: fraction,
+		VersionCodes: source.VersionCodes,
+		ReleaseNotes: source.ReleaseNotes,
+	}
+	fmt.Fprintf(w, "promote %v from %s to %s\n",
+		source.VersionCodes, fromTrack, toTrack)
+	result := &UpdateResult{
+		PackageName: packageName,
+		EditId:      editId,
+		DryRun:      dryRun,
+		Actions: []Action{{
+			Kind:         ActionRelease,
+			Track:        toTrack,
+			Status:       status,
+			UserFraction: fraction,
+			VersionCodes: source.VersionCodes,
+			Notes:        source.ReleaseNotes,
+		}},
+	}
+	if dryRun {
+		return result, session.Delete(ctx)
+	}
+	to.Releases = withRelease(to.Releases, promoted)
+	_, err = pub.UpdateTrack(ctx, packageName, editId, toTrack, to)
+	if err != nil {
+		return nil, fmt.Errorf("updating %s track %s got %v",
+			packageName, toTrack, err)
+	}
+	if err := session.Commit(ctx, notSentForReview); err != nil {
+		return nil, err
+	}
+	result.Committed = true
+	result.NotSentForReview = notSentForReview
+	return result, nil
+}
+
+// promotableRelease returns the newest release on a track that users have,
+// the staged rollout if there is one, which replaces the completed release,
+// or else the completed one.  Drafts are not promoted.
+func promotableRelease(track *ap.Track) *ap.TrackRelease {
+	for _, release := range track.Releases {
+		if release.Status == "inProgress" || release.Status == "halted" {
+			return release
+		}
+	}
+	for _, release := range track.Releases {
+		if release.Status == "completed" {
+			return release
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+// release_test.go
+// Tests releasing and promoting against the fakeplay server.
+package androidpub
+
+import (
+	"context"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/napcatstudio/androidpubtools/androidpub/fakeplay"
+	ap "google.golang.org/api/androidpublisher/v3"
+)
+
+// releaseTestApp returns an app with bundles 1 to 3 and the beta releases.
+func releaseTestApp(beta ...*ap.TrackRelease) *fakeplay.App {
+	return &fakeplay.App{
+		Bundles: []*ap.Bundle{
+			{VersionCode: 1}, {VersionCode: 2}, {VersionCode: 3},
+		},
+		Tracks: map[string]*ap.Track{
+			"beta": {Track: "beta", Releases: beta},
+		},
+	}
+}
+
+func TestPackagePromote(t *testing.T) {
+	completed := &ap.TrackRelease{Status: "completed", VersionCodes: []int64{1}}
+	staged := &ap.TrackRelease{
+		Status: "inProgress", UserFraction: 0.2, VersionCodes: []int64{2}}
+	halted := &ap.TrackRelease{
+		Status: "halted", UserFraction: 0.2, VersionCodes: []int64{2}}
+	draft := &ap.TrackRelease{Status: "draft", VersionCodes: []int64{3}}
+	tests := []struct {
+		name string
+		beta []*ap.TrackRelease
+		want []int64 // Promoted version codes, nil for an error.
+	}{
+		{"completed", []*ap.TrackRelease{completed}, []int64{1}},
+		{"staged over completed",
+			[]*ap.TrackRelease{completed, staged}, []int64{2}},
+		{"staged first", []*ap.TrackRelease{staged, completed}, []int64{2}},
+		{"halted over completed",
+			[]*ap.TrackRelease{completed, halted}, []int64{2}},
+		{"not draft", []*ap.TrackRelease{draft, completed}, []int64{1}},
+		{"only draft", []*ap.TrackRelease{draft}, nil},
+		{"no releases", nil, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, pub := newTestServer(t, releaseTestApp(test.beta...))
+			result, err := PackagePromote(context.Background(), pub,
+				ioutil.Discard, testPackage, "beta", "production", 0,
+				false, false)
+			if test.want == nil {
+				if err == nil {
+					t.Errorf("PackagePromote got %+v, want an error", result)
+				}
+				if got := server.OpenEdits(); got != 0 {
+					t.Errorf("%d open edits, want 0", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("PackagePromote got %v", err)
+			}
+			production := server.App(testPackage).Tracks["production"]
+			if len(production.Releases) != 1 {
+				t.Fatalf("production releases %+v, want 1", production.Releases)
+			}
+			release := production.Releases[0]
+			if release.Status != "completed" ||
+				!reflect.DeepEqual([]int64(release.VersionCodes), test.want) {
+				t.Errorf("promoted %s %v, want completed %v",
+					release.Status, release.VersionCodes, test.want)
+			}
+		})
+	}
+}
+
+func TestCheckNotesLength(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		isErr bool
+	}{
+		{"empty", "", false},
+		{"at the limit", strings.Repeat("a", 500), false},
+		{"over the limit", strings.Repeat("a", 501), true},
+		// Each is two runes but one character.
+		{"combining marks", strings.Repeat("\u0915\u093f", 500), false},
+		{"combining marks over", strings.Repeat("\u0915\u093f", 501), true},
+		{"accents", strings.Repeat("e\u0301", 500), false},
+	}
+	for _, test := range tests {
+		err := checkNotesLength([]*ap.LocalizedText{
+			{Language: "en-US", Text: "Bug fixes."},
+			{Language: "hi-IN", Text: test.text},
+		})
+		if test.isErr != (err != nil) {
+			t.Errorf("%s: checkNotesLength got %v", test.name, err)
+		}
+	}
+}
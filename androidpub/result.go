@@ -0,0 +1,376 @@
+// result.go
+// Contains the results PackageInfo and PackageUpdate return and their
+// JSON, YAML and text output.
+package androidpub
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	ap "google.golang.org/api/androidpublisher/v3"
+)
+
+// Output formats.
+const (
+	OutputText = "text"
+	OutputJson = "json"
+	OutputYaml = "yaml"
+)
+
+// Info is what PackageInfo finds out about a package.
+type Info struct {
+	PackageName string         `json:"packageName"`
+	Details     *ap.AppDetails `json:"details"`
+	Tracks      []*ap.Track    `json:"tracks"`
+	Images      []ImageSet     `json:"images"`
+	Listings    []*ap.Listing  `json:"listings"`
+	Coverage    []Coverage     `json:"coverage"`
+}
+
+// ImageSet is the images of a type in a locale.
+type ImageSet struct {
+	Language  string      `json:"language"`
+	ImageType string      `json:"imageType"`
+	Images    []*ap.Image `json:"images"`
+}
+
+// Coverage is how well a locale is covered by images.
+type Coverage struct {
+	Language string `json:"language"`
+	// Counts is the number of images of each type the locale has.
+	Counts map[string]int `json:"counts"`
+	// Fallback are the types the locale has no images of, so the Play
+	// Store shows the default language ones.
+	Fallback []string `json:"fallback,omitempty"`
+	// BelowMinimum are the types with fewer images, counting the fallback
+	// ones, than the GooglePlayImageSpecs MinCount.
+	BelowMinimum []string `json:"belowMinimum,omitempty"`
+}
+
+// NoImages is true if the locale has no images of its own.
+func (c *Coverage) NoImages() bool {
+	for _, n := range c.Counts {
+		if n != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// imageCoverage works out the coverage of each locale in sets.  Locales
+// missing a type fall back to the defLang images of that type.
+func imageCoverage(sets []ImageSet, defLang string) []Coverage {
+	var coverage []Coverage
+	index := make(map[string]int) // Language to coverage index.
+	defCounts := make(map[string]int)
+	for _, set := range sets {
+		i, ok := index[set.Language]
+		if !ok {
+			i = len(coverage)
+			index[set.Language] = i
+			coverage = append(coverage, Coverage{
+				Language: set.Language,
+				Counts:   make(map[string]int),
+			})
+		}
+		coverage[i].Counts[set.ImageType] = len(set.Images)
+		if set.Language == defLang {
+			defCounts[set.ImageType] = len(set.Images)
+		}
+	}
+	for i := range coverage {
+		c := &coverage[i]
+		for _, imageType := range GooglePlayImageTypes {
+			n := c.Counts[imageType]
+			if n == 0 && c.Language != defLang {
+				if defCounts[imageType] != 0 {
+					c.Fallback = append(c.Fallback, imageType)
+				}
+				n = defCounts[imageType]
+			}
+			if n < GooglePlayImageSpecs[imageType].MinCount {
+				c.BelowMinimum = append(c.BelowMinimum, imageType)
+			}
+		}
+	}
+	return coverage
+}
+
+// Action kinds.
+const (
+	ActionUpdateDetails   = "updateDetails"
+	ActionUpdateListing   = "updateListing"
+	ActionDeleteImage     = "deleteImage"
+	ActionDeleteAllImages = "deleteAllImages"
+	ActionUploadImage     = "uploadImage"
+	// ActionReorderImages says the images of a type are deleted and
+	// uploaded again to change their order.
+	ActionReorderImages = "reorderImages"
+	ActionUploadBinary  = "uploadBinary"
+	ActionRelease       = "release"
+	ActionReleaseNotes  = "releaseNotes"
+)
+
+// Action is a change an update made, or would make for a dry run.
+type Action struct {
+	Kind         string  `json:"kind"`
+	Language     string  `json:"language,omitempty"`
+	ImageType    string  `json:"imageType,omitempty"`
+	ImageId      string  `json:"imageId,omitempty"`
+	File         string  `json:"file,omitempty"`
+	Sha1         string  `json:"sha1,omitempty"`
+	Track        string  `json:"track,omitempty"`
+	Status       string  `json:"status,omitempty"`
+	UserFraction float64 `json:"userFraction,omitempty"`
+	VersionCodes []int64 `json:"versionCodes,omitempty"`
+	// Notes are the translated release notes of a release.
+	Notes []*ap.LocalizedText `json:"notes,omitempty"`
+	// Diff is the unified diff of a listing update.
+	Diff string `json:"diff,omitempty"`
+}
+
+// UpdateResult is what PackageUpdate did, or would do for a dry run.
+type UpdateResult struct {
+	PackageName string `json:"packageName"`
+	EditId      string `json:"editId"`
+	DryRun      bool   `json:"dryRun"`
+	// Committed is true if the edit was committed.  Dry runs and updates
+	// with no actions aren't.
+	Committed bool `json:"committed"`
+	// NotSentForReview is true if the committed changes still have to be
+	// sent for review from the Play Console.
+	NotSentForReview bool `json:"notSentForReview,omitempty"`
+	// Open is true if the changes were made in an edit that was left open
+	// to be committed later.
+	Open    bool     `json:"open,omitempty"`
+	Actions []Action `json:"actions"`
+}
+
+// EditInfo is an edit made by PackageEdit.
+type EditInfo struct {
+	PackageName string    `json:"packageName"`
+	EditId      string    `json:"editId"`
+	Expires     time.Time `json:"expires"`
+}
+
+// WriteText writes the edit ID, on its own, for scripts to use.
+func (edit *EditInfo) WriteText(w io.Writer) {
+	fmt.Fprintln(w, edit.EditId)
+}
+
+// WriteOutput writes an Info or UpdateResult to w in the format,
+// OutputText, OutputJson or OutputYaml.
+func WriteOutput(w io.Writer, format string, v interface{}) error {
+	switch format {
+	case OutputText, "":
+		t, ok := v.(interface{ WriteText(io.Writer) })
+		if !ok {
+			return fmt.Errorf("no text output for %T", v)
+		}
+		t.WriteText(w)
+		return nil
+	case OutputJson:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case OutputYaml:
+		return writeYaml(w, v)
+	}
+	return fmt.Errorf("bad output format '%s'", format)
+}
+
+// writeYaml writes v as YAML using its JSON field names and order.  The
+// API types only have JSON tags.
+func writeYaml(w io.Writer, v interface{}) error {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	// JSON is YAML, it just needs to be in block style.
+	var doc yaml.Node
+	if err := yaml.Unmarshal(bytes, &doc); err != nil {
+		return err
+	}
+	blockStyle(&doc)
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// blockStyle clears the flow and quoting styles from node and its
+// children.  Quotes are still used where they are needed.
+func blockStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
+
+// WriteText writes the info in a readable text form.
+func (info *Info) WriteText(w io.Writer) {
+	details := info.Details
+	fmt.Fprintf(w, "%s %s\n%s\n%s\n",
+		info.PackageName, details.DefaultLanguage,
+		details.ContactEmail,
+		details.ContactWebsite)
+
+	fmt.Fprintf(w, "tracks:\n")
+	for _, track := range info.Tracks {
+		fmt.Fprintf(w, "\t%s\n", track.Track)
+		for _, release := range track.Releases {
+			fmt.Fprintf(w, "\t\t%s %v %s", release.Name,
+				release.VersionCodes, release.Status)
+			if release.UserFraction != 0 {
+				fmt.Fprintf(w, " %v", release.UserFraction)
+			}
+			fmt.Fprintln(w)
+		}
+	}
+
+	for _, set := range info.Images {
+		fmt.Fprintf(w, "%s imageType: %s\n", set.Language, set.ImageType)
+		if len(set.Images) == 0 {
+			fmt.Fprintf(w, "\tno images\n")
+			continue
+		}
+		for _, image := range set.Images {
+			fmt.Fprintf(w, "\t%s sha1:%s %s\n", image.Id, image.Sha1, image.Url)
+		}
+	}
+
+	for _, listing := range info.Listings {
+		fmt.Fprintf(w, "%s %s\n%s\n%s\n",
+			listing.Language, listing.Title,
+			listing.ShortDescription,
+			listing.FullDescription)
+	}
+
+	writeCoverage(w, info.Coverage)
+}
+
+// writeCoverage writes the coverage as a locale by image type table of
+// image counts.  (default) marks a type that falls back to the default
+// language images.  A ! marks a type below the minimum.
+func writeCoverage(w io.Writer, coverage []Coverage) {
+	if len(coverage) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "coverage:\n")
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	fmt.Fprintf(tw, "\t%s\n", strings.Join(GooglePlayImageTypes, "\t"))
+	for _, c := range coverage {
+		cells := make([]string, len(GooglePlayImageTypes))
+		for i, imageType := range GooglePlayImageTypes {
+			cells[i] = fmt.Sprint(c.Counts[imageType])
+			if contains(c.Fallback, imageType) {
+				cells[i] = "(default)"
+			}
+			if contains(c.BelowMinimum, imageType) {
+				cells[i] += "!"
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", c.Language, strings.Join(cells, "\t"))
+	}
+	tw.Flush()
+	for _, c := range coverage {
+		if c.NoImages() {
+			fmt.Fprintf(w, "%s has no images of its own\n", c.Language)
+		}
+		if len(c.BelowMinimum) != 0 {
+			fmt.Fprintf(w, "%s below minimum for %s\n",
+				c.Language, strings.Join(c.BelowMinimum, " "))
+		}
+	}
+}
+
+// contains is true if list has s.
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteText writes the update result in a readable text form, one line for
+// each action with the diffs of listing updates.
+func (result *UpdateResult) WriteText(w io.Writer) {
+	would := ""
+	if result.DryRun {
+		would = "would "
+	}
+	for _, a := range result.Actions {
+		switch a.Kind {
+		case ActionUpdateDetails:
+			fmt.Fprintf(w, "%supdate details\n", would)
+			fmt.Fprint(w, a.Diff)
+		case ActionUpdateListing:
+			fmt.Fprintf(w, "%supdate listing %s\n", would, a.Language)
+			fmt.Fprint(w, a.Diff)
+		case ActionDeleteImage:
+			fmt.Fprintf(w, "%sdelete %s %s %s sha1:%s\n",
+				would, a.Language, a.ImageType, a.ImageId, a.Sha1)
+		case ActionDeleteAllImages:
+			fmt.Fprintf(w, "%sdelete all %s %s\n",
+				would, a.Language, a.ImageType)
+		case ActionReorderImages:
+			fmt.Fprintf(w, "%sreorder %s %s\n",
+				would, a.Language, a.ImageType)
+		case ActionUploadImage:
+			file := a.File
+			if file == "" {
+				// Already uploaded to an open edit.
+				file = a.ImageId
+			}
+			fmt.Fprintf(w, "%supload %s %s %s sha1:%s\n",
+				would, a.Language, a.ImageType, file, a.Sha1)
+		case ActionUploadBinary:
+			fmt.Fprintf(w, "%supload %s\n", would, a.File)
+		case ActionRelease:
+			fmt.Fprintf(w, "%srelease %v to %s as %s",
+				would, a.VersionCodes, a.Track, a.Status)
+			if a.UserFraction != 0 {
+				fmt.Fprintf(w, " for %v of users", a.UserFraction)
+			}
+			fmt.Fprintln(w)
+			writeNotes(w, a.Notes)
+		case ActionReleaseNotes:
+			fmt.Fprintf(w, "%sset %s release %v notes\n",
+				would, a.Track, a.VersionCodes)
+			writeNotes(w, a.Notes)
+		}
+	}
+	switch {
+	case len(result.Actions) == 0:
+		fmt.Fprintf(w, "no changes for %s\n", result.PackageName)
+	case result.Committed && result.NotSentForReview:
+		fmt.Fprintf(w, "committed %d changes to %s, not sent for review\n",
+			len(result.Actions), result.PackageName)
+	case result.Committed:
+		fmt.Fprintf(w, "committed %d changes to %s\n",
+			len(result.Actions), result.PackageName)
+	case result.Open:
+		fmt.Fprintf(w, "%d changes in %s edit %s\n",
+			len(result.Actions), result.PackageName, result.EditId)
+	default:
+		fmt.Fprintf(w, "%d changes not committed to %s\n",
+			len(result.Actions), result.PackageName)
+	}
+}
+
+// writeNotes writes release notes, language by language.
+func writeNotes(w io.Writer, notes []*ap.LocalizedText) {
+	for _, note := range notes {
+		fmt.Fprintf(w, "%s notes:\n%s\n", note.Language, note.Text)
+	}
+}
@@ -0,0 +1,520 @@
+// retry.go
+// Contains a Publisher that retries calls that fail because of the Play
+// Developer API rate limits or transient server errors and that limits how
+// fast calls are made.
+package androidpub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	ap "google.golang.org/api/androidpublisher/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// RetryOptions says how calls are retried.
+type RetryOptions struct {
+	// MaxAttempts is the most times a call is made, including the first.
+	MaxAttempts int
+	// MaxElapsed is the most time a call and its retries can take, 0 for
+	// no limit.
+	MaxElapsed time.Duration
+	// InitialBackoff is the wait before the first retry.  It doubles for
+	// each retry up to MaxBackoff.  A Retry-After from the server is used
+	// instead if it is longer.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// MaxRate is the most calls started per second, 0 for no limit.  When
+	// the server says a limit was hit all the calls wait.
+	MaxRate float64
+}
+
+// DefaultRetryOptions are the retry options androidpkg uses by default.
+var DefaultRetryOptions = RetryOptions{
+	MaxAttempts:    5,
+	MaxElapsed:     5 * time.Minute,
+	InitialBackoff: time.Second,
+	MaxBackoff:     32 * time.Second,
+	MaxRate:        20,
+}
+
+// retryableCodes are the HTTP status codes for rate limits and transient
+// server errors.
+var retryableCodes = map[int]bool{
+	http.StatusTooManyRequests:     true,
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusGatewayTimeout:      true,
+}
+
+// NewRetryPublisher returns a Publisher that retries the calls to pub that
+// fail with a rate limit or transient error.  The waits between attempts
+// are jittered exponential backoff.  Uploads are retried by opening the
+// file again.  Commits and uploads aren't idempotent, a server error
+// doesn't say whether they were made.  Commits and image uploads check
+// whether they were made before trying again, bundle and APK uploads are
+// only retried when they can't have been made.  A delete that is retried
+// and not found was made.  It is safe to use from several goroutines.
+func NewRetryPublisher(pub Publisher, opts RetryOptions) Publisher {
+	r := &retryPublisher{pub: pub, opts: opts}
+	if opts.MaxRate > 0 {
+		r.limit = &rateLimiter{
+			interval: time.Duration(float64(time.Second) / opts.MaxRate),
+		}
+	}
+	return r
+}
+
+// retryPublisher is a Publisher that retries another Publisher.
+type retryPublisher struct {
+	pub   Publisher
+	opts  RetryOptions
+	limit *rateLimiter
+}
+
+// rateLimiter spaces out calls by interval.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time // When the next call can start.
+}
+
+// wait waits until a call can start.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	start := time.Now()
+	if l.next.After(start) {
+		start = l.next
+	}
+	l.next = start.Add(l.interval)
+	l.mu.Unlock()
+	return sleep(ctx, time.Until(start))
+}
+
+// pause stops calls starting for d.
+func (l *rateLimiter) pause(d time.Duration) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until := time.Now().Add(d); until.After(l.next) {
+		l.next = until
+	}
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// retry calls call until it works, has an error that can't be retried or
+// runs out of attempts or time.  what describes the call for the retry
+// messages.
+func (r *retryPublisher) retry(
+	ctx context.Context, what string, call func() error) error {
+
+	return r.retryIf(ctx, what, retryable, call)
+}
+
+// retryIf is retry with canRetry saying which errors can be retried and
+// how long the server asked to wait.
+func (r *retryPublisher) retryIf(
+	ctx context.Context, what string,
+	canRetry func(error) (time.Duration, bool), call func() error) error {
+
+	start := time.Now()
+	backoff := r.opts.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		if err := r.limit.wait(ctx); err != nil {
+			return err
+		}
+		err := call()
+		if err == nil {
+			return nil
+		}
+		retryAfter, ok := canRetry(err)
+		if !ok || attempt >= r.opts.MaxAttempts {
+			return err
+		}
+		wait := jitter(backoff)
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		if retryAfter > 0 {
+			// Everyone needs to wait.
+			r.limit.pause(retryAfter)
+		}
+		if r.opts.MaxElapsed > 0 && time.Since(start)+wait > r.opts.MaxElapsed {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "retrying %s in %v (%d/%d) after %v\n",
+			what, wait.Round(time.Millisecond),
+			attempt+1, r.opts.MaxAttempts, err)
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+		backoff *= 2
+		if backoff > r.opts.MaxBackoff {
+			backoff = r.opts.MaxBackoff
+		}
+	}
+}
+
+// retryable is true if err is a rate limit or transient API error.  The
+// wait the server asked for in Retry-After, if any, is returned too.
+func retryable(err error) (time.Duration, bool) {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || !retryableCodes[apiErr.Code] {
+		return 0, false
+	}
+	return retryAfter(apiErr.Header.Get("Retry-After")), true
+}
+
+// retryableUnsent is true if err is a rate limit or the call was never
+// sent, so a call that isn't idempotent can be retried.  A rate limited
+// call is refused before it is made.
+func retryableUnsent(err error) (time.Duration, bool) {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		if apiErr.Code != http.StatusTooManyRequests {
+			return 0, false
+		}
+		return retryAfter(apiErr.Header.Get("Retry-After")), true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return 0, true
+	}
+	return 0, false
+}
+
+// isNotFound is true if err is an API not found error.
+func isNotFound(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
+
+// retryAfter parses a Retry-After header, either seconds or an HTTP date.
+// It returns 0 if there isn't one.
+func retryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if when, err := http.ParseTime(value); err == nil {
+		return time.Until(when)
+	}
+	return 0
+}
+
+// jitter returns a random wait between half and all of backoff.
+func jitter(backoff time.Duration) time.Duration {
+	half := int64(backoff / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+func (r *retryPublisher) InsertEdit(
+	ctx context.Context, packageName string) (edit *ap.AppEdit, err error) {
+
+	err = r.retry(ctx, "insert edit", func() (err error) {
+		edit, err = r.pub.InsertEdit(ctx, packageName)
+		return
+	})
+	return
+}
+
+func (r *retryPublisher) GetEdit(
+	ctx context.Context,
+	packageName, editId string) (edit *ap.AppEdit, err error) {
+
+	err = r.retry(ctx, "get edit", func() (err error) {
+		edit, err = r.pub.GetEdit(ctx, packageName, editId)
+		return
+	})
+	return
+}
+
+func (r *retryPublisher) ValidateEdit(
+	ctx context.Context,
+	packageName, editId string) (edit *ap.AppEdit, err error) {
+
+	err = r.retry(ctx, "validate edit", func() (err error) {
+		edit, err = r.pub.ValidateEdit(ctx, packageName, editId)
+		return
+	})
+	return
+}
+
+func (r *retryPublisher) CommitEdit(
+	ctx context.Context,
+	packageName, editId string,
+	notSentForReview bool) (edit *ap.AppEdit, err error) {
+
+	// A committed edit is gone, so after a failed commit the edit is got to
+	// see whether the commit was made anyway.
+	failed := false
+	err = r.retry(ctx, "commit edit", func() (err error) {
+		if failed {
+			edit, err = r.pub.GetEdit(ctx, packageName, editId)
+			if isNotFound(err) {
+				edit = &ap.AppEdit{Id: editId}
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+		edit, err = r.pub.CommitEdit(
+			ctx, packageName, editId, notSentForReview)
+		failed = err != nil
+		return
+	})
+	return
+}
+
+func (r *retryPublisher) DeleteEdit(
+	ctx context.Context, packageName, editId string) error {
+
+	// A failed delete may have been made anyway, then it isn't found when
+	// it is tried again.
+	tried := false
+	return r.retry(ctx, "delete edit", func() error {
+		err := r.pub.DeleteEdit(ctx, packageName, editId)
+		if tried && isNotFound(err) {
+			return nil
+		}
+		tried = true
+		return err
+	})
+}
+
+func (r *retryPublisher) GetDetails(
+	ctx context.Context,
+	packageName, editId string) (details *ap.AppDetails, err error) {
+
+	err = r.retry(ctx, "get details", func() (err error) {
+		details, err = r.pub.GetDetails(ctx, packageName, editId)
+		return
+	})
+	return
+}
+
+func (r *retryPublisher) UpdateDetails(
+	ctx context.Context,
+	packageName, editId string,
+	update *ap.AppDetails) (details *ap.AppDetails, err error) {
+
+	err = r.retry(ctx, "update details", func() (err error) {
+		details, err = r.pub.UpdateDetails(ctx, packageName, editId, update)
+		return
+	})
+	return
+}
+
+func (r *retryPublisher) ListListings(
+	ctx context.Context,
+	packageName, editId string) (listings []*ap.Listing, err error) {
+
+	err = r.retry(ctx, "list listings", func() (err error) {
+		listings, err = r.pub.ListListings(ctx, packageName, editId)
+		return
+	})
+	return
+}
+
+func (r *retryPublisher) GetListing(
+	ctx context.Context,
+	packageName, editId, language string) (listing *ap.Listing, err error) {
+
+	err = r.retry(ctx, "get listing "+language, func() (err error) {
+		listing, err = r.pub.GetListing(ctx, packageName, editId, language)
+		return
+	})
+	return
+}
+
+func (r *retryPublisher) UpdateListing(
+	ctx context.Context,
+	packageName, editId, language string,
+	update *ap.Listing) (listing *ap.Listing, err error) {
+
+	err = r.retry(ctx, "update listing "+language, func() (err error) {
+		listing, err = r.pub.UpdateListing(
+			ctx, packageName, editId, language, update)
+		return
+	})
+	return
+}
+
+func (r *retryPublisher) ListImages(
+	ctx context.Context,
+	packageName, editId, language, imageType string) (
+	images []*ap.Image, err error) {
+
+	what := "list images " + language + " " + imageType
+	err = r.retry(ctx, what, func() (err error) {
+		images, err = r.pub.ListImages(
+			ctx, packageName, editId, language, imageType)
+		return
+	})
+	return
+}
+
+func (r *retryPublisher) UploadImage(
+	ctx context.Context,
+	packageName, editId, language, imageType, file string) (
+	image *ap.Image, err error) {
+
+	// Uploaded images go last, so after a failed upload the images are
+	// listed to see whether the upload was made anyway.
+	failed := false
+	err = r.retry(ctx, "upload "+file, func() (err error) {
+		if failed {
+			image, err = r.uploadedImage(
+				ctx, packageName, editId, language, imageType, file)
+			if err != nil || image != nil {
+				return err
+			}
+		}
+		image, err = r.pub.UploadImage(
+			ctx, packageName, editId, language, imageType, file)
+		failed = err != nil
+		return
+	})
+	return
+}
+
+// uploadedImage returns the last image of the type if it is file, nil if
+// it isn't.
+func (r *retryPublisher) uploadedImage(
+	ctx context.Context,
+	packageName, editId, language, imageType, file string) (*ap.Image, error) {
+
+	want, err := fileSha1(file)
+	if err != nil {
+		return nil, err
+	}
+	images, err := r.pub.ListImages(
+		ctx, packageName, editId, language, imageType)
+	if err != nil {
+		return nil, err
+	}
+	if n := len(images); n > 0 && images[n-1].Sha1 == want {
+		return images[n-1], nil
+	}
+	return nil, nil
+}
+
+func (r *retryPublisher) DeleteImage(
+	ctx context.Context,
+	packageName, editId, language, imageType, imageId string) error {
+
+	what := "delete image " + language + " " + imageType + " " + imageId
+	// A failed delete may have been made anyway, then it isn't found when
+	// it is tried again.
+	tried := false
+	return r.retry(ctx, what, func() error {
+		err := r.pub.DeleteImage(
+			ctx, packageName, editId, language, imageType, imageId)
+		if tried && isNotFound(err) {
+			return nil
+		}
+		tried = true
+		return err
+	})
+}
+
+func (r *retryPublisher) DeleteAllImages(
+	ctx context.Context,
+	packageName, editId, language, imageType string) (
+	deleted []*ap.Image, err error) {
+
+	what := "delete all images " + language + " " + imageType
+	err = r.retry(ctx, what, func() (err error) {
+		deleted, err = r.pub.DeleteAllImages(
+			ctx, packageName, editId, language, imageType)
+		return
+	})
+	return
+}
+
+func (r *retryPublisher) ListTracks(
+	ctx context.Context,
+	packageName, editId string) (tracks []*ap.Track, err error) {
+
+	err = r.retry(ctx, "list tracks", func() (err error) {
+		tracks, err = r.pub.ListTracks(ctx, packageName, editId)
+		return
+	})
+	return
+}
+
+func (r *retryPublisher) GetTrack(
+	ctx context.Context,
+	packageName, editId, name string) (track *ap.Track, err error) {
+
+	err = r.retry(ctx, "get track "+name, func() (err error) {
+		track, err = r.pub.GetTrack(ctx, packageName, editId, name)
+		return
+	})
+	return
+}
+
+func (r *retryPublisher) UpdateTrack(
+	ctx context.Context,
+	packageName, editId, name string,
+	update *ap.Track) (track *ap.Track, err error) {
+
+	err = r.retry(ctx, "update track "+name, func() (err error) {
+		track, err = r.pub.UpdateTrack(ctx, packageName, editId, name, update)
+		return
+	})
+	return
+}
+
+func (r *retryPublisher) UploadBundle(
+	ctx context.Context,
+	packageName, editId, file string) (bundle *ap.Bundle, err error) {
+
+	err = r.retryIf(ctx, "upload "+file, retryableUnsent, func() (err error) {
+		bundle, err = r.pub.UploadBundle(ctx, packageName, editId, file)
+		return
+	})
+	return
+}
+
+func (r *retryPublisher) UploadApk(
+	ctx context.Context,
+	packageName, editId, file string) (apk *ap.Apk, err error) {
+
+	err = r.retryIf(ctx, "upload "+file, retryableUnsent, func() (err error) {
+		apk, err = r.pub.UploadApk(ctx, packageName, editId, file)
+		return
+	})
+	return
+}
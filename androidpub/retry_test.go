@@ -0,0 +1,308 @@
+// retry_test.go
+// Tests retrying Play Developer API calls against the fakeplay server.
+package androidpub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/napcatstudio/androidpubtools/androidpub/fakeplay"
+	"google.golang.org/api/googleapi"
+)
+
+// testRetryOptions retry quickly.
+var testRetryOptions = RetryOptions{
+	MaxAttempts:    3,
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     4 * time.Millisecond,
+}
+
+func apiError(code int, retryAfter string) error {
+	err := &googleapi.Error{Code: code, Header: make(http.Header)}
+	if retryAfter != "" {
+		err.Header.Set("Retry-After", retryAfter)
+	}
+	return err
+}
+
+func TestRetryable(t *testing.T) {
+	dial := &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+	read := &net.OpError{Op: "read", Err: errors.New("connection reset")}
+	tests := []struct {
+		name       string
+		err        error
+		wait       time.Duration
+		ok         bool
+		unsentWait time.Duration
+		unsentOk   bool
+	}{
+		{"rate limit", apiError(429, ""), 0, true, 0, true},
+		{"rate limit wait", apiError(429, "3"), 3 * time.Second, true,
+			3 * time.Second, true},
+		{"server error", apiError(500, ""), 0, true, 0, false},
+		{"bad gateway", apiError(502, ""), 0, true, 0, false},
+		{"unavailable", apiError(503, "2"), 2 * time.Second, true, 0, false},
+		{"gateway timeout", apiError(504, ""), 0, true, 0, false},
+		{"bad request", apiError(400, ""), 0, false, 0, false},
+		{"not found", apiError(404, ""), 0, false, 0, false},
+		{"wrapped", fmt.Errorf("commit got %w", apiError(503, "")),
+			0, true, 0, false},
+		{"dial", dial, 0, false, 0, true},
+		{"read", read, 0, false, 0, false},
+		{"other", errors.New("other"), 0, false, 0, false},
+	}
+	for _, test := range tests {
+		wait, ok := retryable(test.err)
+		if wait != test.wait || ok != test.ok {
+			t.Errorf("%s: retryable got %v %v, want %v %v",
+				test.name, wait, ok, test.wait, test.ok)
+		}
+		wait, ok = retryableUnsent(test.err)
+		if wait != test.unsentWait || ok != test.unsentOk {
+			t.Errorf("%s: retryableUnsent got %v %v, want %v %v",
+				test.name, wait, ok, test.unsentWait, test.unsentOk)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		value    string
+		min, max time.Duration
+	}{
+		{"", 0, 0},
+		{"7", 7 * time.Second, 7 * time.Second},
+		{"soon", 0, 0},
+		{time.Now().Add(time.Minute).UTC().Format(http.TimeFormat),
+			58 * time.Second, time.Minute},
+	}
+	for _, test := range tests {
+		got := retryAfter(test.value)
+		if got < test.min || got > test.max {
+			t.Errorf("retryAfter(%q) got %v, want %v to %v",
+				test.value, got, test.min, test.max)
+		}
+	}
+}
+
+// newRetryTestServer returns a fakeplay server, a retrying Publisher for
+// it with opts and an open edit.
+func newRetryTestServer(t *testing.T, opts RetryOptions) (
+	*fakeplay.Server, Publisher, string) {
+
+	t.Helper()
+	server, pub := newTestServer(t, nil)
+	pub = NewRetryPublisher(pub, opts)
+	edit, err := pub.InsertEdit(context.Background(), testPackage)
+	if err != nil {
+		t.Fatalf("InsertEdit got %v", err)
+	}
+	return server, pub, edit.Id
+}
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name       string
+		failures   int
+		code       int
+		retryAfter time.Duration
+		maxElapsed time.Duration
+		isErr      bool
+	}{
+		{name: "no failures"},
+		{name: "retried", failures: 2, code: 503},
+		{name: "out of attempts", failures: 3, code: 503, isErr: true},
+		{name: "rate limit", failures: 1, code: 429},
+		{name: "not retryable", failures: 1, code: 400, isErr: true},
+		{name: "out of time", failures: 1, code: 429,
+			retryAfter: time.Hour, maxElapsed: time.Minute, isErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := testRetryOptions
+			opts.MaxElapsed = test.maxElapsed
+			server, pub, editId := newRetryTestServer(t, opts)
+			server.Fail(test.failures, test.code, test.retryAfter)
+			_, err := pub.GetDetails(
+				context.Background(), testPackage, editId)
+			if test.isErr {
+				var apiErr *googleapi.Error
+				if !errors.As(err, &apiErr) || apiErr.Code != test.code {
+					t.Errorf("GetDetails got %v, want a %d", err, test.code)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("GetDetails got %v", err)
+			}
+		})
+	}
+}
+
+func TestRetryCancelled(t *testing.T) {
+	opts := testRetryOptions
+	opts.InitialBackoff = time.Hour
+	opts.MaxBackoff = time.Hour
+	server, pub, editId := newRetryTestServer(t, opts)
+	server.Fail(1, 503, 0)
+	ctx, cancel := context.WithTimeout(
+		context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := pub.GetDetails(ctx, testPackage, editId)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GetDetails got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestRetryCommitMade(t *testing.T) {
+	server, pub, editId := newRetryTestServer(t, testRetryOptions)
+	// The commit is made but its response is lost.
+	server.FailMade(1, 503)
+	edit, err := pub.CommitEdit(
+		context.Background(), testPackage, editId, false)
+	if err != nil {
+		t.Fatalf("CommitEdit got %v", err)
+	}
+	if edit.Id != editId {
+		t.Errorf("CommitEdit got edit %s, want %s", edit.Id, editId)
+	}
+	if got := server.Commits(testPackage); got != 1 {
+		t.Errorf("%d commits, want 1", got)
+	}
+
+	// Not made, so committed again.
+	edit, err = pub.InsertEdit(context.Background(), testPackage)
+	if err != nil {
+		t.Fatalf("InsertEdit got %v", err)
+	}
+	server.Fail(1, 503, 0)
+	_, err = pub.CommitEdit(context.Background(), testPackage, edit.Id, false)
+	if err != nil {
+		t.Fatalf("CommitEdit got %v", err)
+	}
+	if got := server.Commits(testPackage); got != 2 {
+		t.Errorf("%d commits, want 2", got)
+	}
+}
+
+func TestRetryUploadImageMade(t *testing.T) {
+	for _, made := range []bool{true, false} {
+		t.Run(fmt.Sprintf("made %v", made), func(t *testing.T) {
+			server, pub, editId := newRetryTestServer(t, testRetryOptions)
+			file := filepath.Join(t.TempDir(), "en-US_0.png")
+			sha1 := writeTestPng(t, file, 10)
+			if made {
+				server.FailMade(1, 500)
+			} else {
+				server.Fail(1, 500, 0)
+			}
+			image, err := pub.UploadImage(context.Background(),
+				testPackage, editId, "en-US", "phoneScreenshots", file)
+			if err != nil {
+				t.Fatalf("UploadImage got %v", err)
+			}
+			if image.Sha1 != sha1 {
+				t.Errorf("UploadImage got SHA1 %s, want %s", image.Sha1, sha1)
+			}
+			images, err := pub.ListImages(context.Background(),
+				testPackage, editId, "en-US", "phoneScreenshots")
+			if err != nil {
+				t.Fatalf("ListImages got %v", err)
+			}
+			if len(images) != 1 {
+				t.Errorf("%d images uploaded, want 1", len(images))
+			}
+		})
+	}
+}
+
+func TestRetryUploadBundle(t *testing.T) {
+	tests := []struct {
+		name    string
+		fail    func(*fakeplay.Server)
+		isErr   bool
+		bundles int
+	}{
+		{"rate limit retried", func(s *fakeplay.Server) { s.Fail(1, 429, 0) },
+			false, 1},
+		{"server error not retried",
+			func(s *fakeplay.Server) { s.Fail(1, 503, 0) }, true, 0},
+		{"made not retried",
+			func(s *fakeplay.Server) { s.FailMade(1, 503) }, true, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, pub, editId := newRetryTestServer(t, testRetryOptions)
+			file := filepath.Join(t.TempDir(), "app.aab")
+			err := ioutil.WriteFile(file, []byte("bundle"), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.fail(server)
+			_, err = pub.UploadBundle(
+				context.Background(), testPackage, editId, file)
+			if test.isErr != (err != nil) {
+				t.Errorf("UploadBundle got %v", err)
+			}
+			_, err = pub.CommitEdit(
+				context.Background(), testPackage, editId, false)
+			if err != nil {
+				t.Fatalf("CommitEdit got %v", err)
+			}
+			got := len(server.App(testPackage).Bundles)
+			if got != test.bundles {
+				t.Errorf("%d bundles, want %d", got, test.bundles)
+			}
+		})
+	}
+}
+
+func TestRetryDeleteMade(t *testing.T) {
+	server, pub, editId := newRetryTestServer(t, testRetryOptions)
+	ctx := context.Background()
+	file := filepath.Join(t.TempDir(), "en-US_0.png")
+	writeTestPng(t, file, 10)
+	image, err := pub.UploadImage(
+		ctx, testPackage, editId, "en-US", "phoneScreenshots", file)
+	if err != nil {
+		t.Fatalf("UploadImage got %v", err)
+	}
+
+	// Deleting what isn't there is still an error.
+	err = pub.DeleteImage(
+		ctx, testPackage, editId, "en-US", "phoneScreenshots", "missing")
+	if !isNotFound(err) {
+		t.Errorf("DeleteImage of a missing image got %v, want not found", err)
+	}
+
+	// The delete is made but its response is lost.
+	server.FailMade(1, 503)
+	err = pub.DeleteImage(
+		ctx, testPackage, editId, "en-US", "phoneScreenshots", image.Id)
+	if err != nil {
+		t.Errorf("DeleteImage got %v", err)
+	}
+	images, err := pub.ListImages(
+		ctx, testPackage, editId, "en-US", "phoneScreenshots")
+	if err != nil {
+		t.Fatalf("ListImages got %v", err)
+	}
+	if len(images) != 0 {
+		t.Errorf("%d images after delete, want 0", len(images))
+	}
+
+	server.FailMade(1, 503)
+	if err := pub.DeleteEdit(ctx, testPackage, editId); err != nil {
+		t.Errorf("DeleteEdit got %v", err)
+	}
+	if got := server.OpenEdits(); got != 0 {
+		t.Errorf("%d open edits, want 0", got)
+	}
+}
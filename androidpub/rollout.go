@@ -0,0 +1,191 @@
+// rollout.go
+// Contains functions for managing staged rollouts of track releases.
+package androidpub
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	ap "google.golang.org/api/androidpublisher/v3"
+)
+
+// PackageRollout increases the user fraction of the in progress release on
+// the track.  If notSentForReview is set the change has to be sent for
+// review from the Play Console, apps with managed publishing need it.
+// Progress is written to w.  The result has the changed release.  The same
+// goes for PackageHalt, PackageResume and PackageComplete.
+func PackageRollout(
+	ctx context.Context, pub Publisher, w io.Writer,
+	packageName, trackName string,
+	fraction float64, dryRun, notSentForReview bool) (*UpdateResult, error) {
+
+	return changeRollout(
+		ctx, pub, packageName, trackName, dryRun, notSentForReview,
+		func(track *ap.Track) (*ap.TrackRelease, error) {
+			staged, err := stagedRelease(track)
+			if err != nil {
+				return nil, err
+			}
+			if staged.Status != "inProgress" {
+				return nil, fmt.Errorf("release %v is %s, resume it first",
+					staged.VersionCodes, staged.Status)
+			}
+			if fraction >= 1 {
+				return nil, fmt.Errorf("fraction %v is not below 1, complete the release instead",
+					fraction)
+			}
+			if fraction <= staged.UserFraction {
+				return nil, fmt.Errorf("fraction %v must be more than the current %v",
+					fraction, staged.UserFraction)
+			}
+			fmt.Fprintf(w, "rollout %v from %v to %v\n",
+				staged.VersionCodes, staged.UserFraction, fraction)
+			staged.UserFraction = fraction
+			return staged, nil
+		})
+}
+
+// PackageHalt halts the in progress release on the track.
+func PackageHalt(
+	ctx context.Context, pub Publisher, w io.Writer,
+	packageName, trackName string,
+	dryRun, notSentForReview bool) (*UpdateResult, error) {
+
+	return changeRollout(
+		ctx, pub, packageName, trackName, dryRun, notSentForReview,
+		func(track *ap.Track) (*ap.TrackRelease, error) {
+			staged, err := stagedRelease(track)
+			if err != nil {
+				return nil, err
+			}
+			if staged.Status != "inProgress" {
+				return nil, fmt.Errorf("release %v is %s, only inProgress can be halted",
+					staged.VersionCodes, staged.Status)
+			}
+			fmt.Fprintf(w, "halt %v at %v\n",
+				staged.VersionCodes, staged.UserFraction)
+			staged.Status = "halted"
+			return staged, nil
+		})
+}
+
+// PackageResume resumes the halted release on the track.
+func PackageResume(
+	ctx context.Context, pub Publisher, w io.Writer,
+	packageName, trackName string,
+	dryRun, notSentForReview bool) (*UpdateResult, error) {
+
+	return changeRollout(
+		ctx, pub, packageName, trackName, dryRun, notSentForReview,
+		func(track *ap.Track) (*ap.TrackRelease, error) {
+			staged, err := stagedRelease(track)
+			if err != nil {
+				return nil, err
+			}
+			if staged.Status != "halted" {
+				return nil, fmt.Errorf("release %v is %s, only halted can be resumed",
+					staged.VersionCodes, staged.Status)
+			}
+			fmt.Fprintf(w, "resume %v at %v\n",
+				staged.VersionCodes, staged.UserFraction)
+			staged.Status = "inProgress"
+			return staged, nil
+		})
+}
+
+// PackageComplete rolls the in progress, or halted, release on the track out
+// to all users.  It replaces the previously completed release, drafts are
+// kept.
+func PackageComplete(
+	ctx context.Context, pub Publisher, w io.Writer,
+	packageName, trackName string,
+	dryRun, notSentForReview bool) (*UpdateResult, error) {
+
+	return changeRollout(
+		ctx, pub, packageName, trackName, dryRun, notSentForReview,
+		func(track *ap.Track) (*ap.TrackRelease, error) {
+			staged, err := stagedRelease(track)
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(w, "complete %v from %v\n",
+				staged.VersionCodes, staged.UserFraction)
+			staged.Status = "completed"
+			staged.UserFraction = 0
+			releases := []*ap.TrackRelease{staged}
+			for _, release := range track.Releases {
+				if release.Status == "draft" {
+					releases = append(releases, release)
+				}
+			}
+			track.Releases = releases
+			return staged, nil
+		})
+}
+
+// changeRollout gets the track in a new edit, changes it and commits it.
+// change returns the release it changed.
+func changeRollout(
+	ctx context.Context, pub Publisher, packageName, trackName string,
+	dryRun, notSentForReview bool,
+	change func(*ap.Track) (*ap.TrackRelease, error)) (*UpdateResult, error) {
+
+	session, err := NewEditSession(ctx, pub, packageName)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+	editId := session.Id
+	track, err := pub.GetTrack(ctx, packageName, editId, trackName)
+	if err != nil {
+		return nil, fmt.Errorf("getting %s track %s got %v",
+			packageName, trackName, err)
+	}
+	changed, err := change(track)
+	if err != nil {
+		return nil, fmt.Errorf("%s track %s %v", packageName, trackName, err)
+	}
+	result := &UpdateResult{
+		PackageName: packageName,
+		EditId:      editId,
+		DryRun:      dryRun,
+		Actions: []Action{{
+			Kind:         ActionRelease,
+			Track:        trackName,
+			Status:       changed.Status,
+			UserFraction: changed.UserFraction,
+			VersionCodes: changed.VersionCodes,
+		}},
+	}
+	if dryRun {
+		return result, session.Delete(ctx)
+	}
+	_, err = pub.UpdateTrack(ctx, packageName, editId, trackName, track)
+	if err != nil {
+		return nil, fmt.Errorf("updating %s track %s got %v",
+			packageName, trackName, err)
+	}
+	if err := session.Commit(ctx, notSentForReview); err != nil {
+		return nil, err
+	}
+	result.Committed = true
+	result.NotSentForReview = notSentForReview
+	return result, nil
+}
+
+// stagedRelease returns the in progress or halted release on the track.
+func stagedRelease(track *ap.Track) (*ap.TrackRelease, error) {
+	for _, release := range track.Releases {
+		if release.Status == "inProgress" || release.Status == "halted" {
+			return release, nil
+		}
+	}
+	for _, release := range track.Releases {
+		if release.Status == "completed" {
+			return nil, fmt.Errorf("release %v is already completed",
+				release.VersionCodes)
+		}
+	}
+	return nil, fmt.Errorf("has no staged rollout")
+}
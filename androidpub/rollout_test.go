@@ -0,0 +1,130 @@
+// rollout_test.go
+// Tests the staged rollout changes against the fakeplay server.
+package androidpub
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	ap "google.golang.org/api/androidpublisher/v3"
+)
+
+// releasesString describes releases as "status [codes] fraction; ...".
+func releasesString(releases []*ap.TrackRelease) string {
+	var parts []string
+	for _, release := range releases {
+		parts = append(parts, fmt.Sprintf("%s %v %v",
+			release.Status, release.VersionCodes, release.UserFraction))
+	}
+	return strings.Join(parts, "; ")
+}
+
+func TestRollout(t *testing.T) {
+	completed := &ap.TrackRelease{Status: "completed", VersionCodes: []int64{1}}
+	staged := &ap.TrackRelease{
+		Status: "inProgress", UserFraction: 0.1, VersionCodes: []int64{2}}
+	halted := &ap.TrackRelease{
+		Status: "halted", UserFraction: 0.1, VersionCodes: []int64{2}}
+	draft := &ap.TrackRelease{Status: "draft", VersionCodes: []int64{3}}
+
+	type change func(pub Publisher, dryRun bool) (*UpdateResult, error)
+	rollout := func(fraction float64) change {
+		return func(pub Publisher, dryRun bool) (*UpdateResult, error) {
+			return PackageRollout(context.Background(), pub, ioutil.Discard,
+				testPackage, "production", fraction, dryRun, false)
+		}
+	}
+	halt := func(pub Publisher, dryRun bool) (*UpdateResult, error) {
+		return PackageHalt(context.Background(), pub, ioutil.Discard,
+			testPackage, "production", dryRun, false)
+	}
+	resume := func(pub Publisher, dryRun bool) (*UpdateResult, error) {
+		return PackageResume(context.Background(), pub, ioutil.Discard,
+			testPackage, "production", dryRun, false)
+	}
+	complete := func(pub Publisher, dryRun bool) (*UpdateResult, error) {
+		return PackageComplete(context.Background(), pub, ioutil.Discard,
+			testPackage, "production", dryRun, false)
+	}
+
+	tests := []struct {
+		name     string
+		releases []*ap.TrackRelease
+		change   change
+		dryRun   bool
+		want     string // The releases after, "" for an error.
+	}{
+		{"increase", []*ap.TrackRelease{completed, staged}, rollout(0.5),
+			false, "completed [1] 0; inProgress [2] 0.5"},
+		{"increase dry run", []*ap.TrackRelease{completed, staged},
+			rollout(0.5), true, "completed [1] 0; inProgress [2] 0.1"},
+		{"same fraction", []*ap.TrackRelease{completed, staged},
+			rollout(0.1), false, ""},
+		{"decrease", []*ap.TrackRelease{completed, staged},
+			rollout(0.05), false, ""},
+		{"to all", []*ap.TrackRelease{completed, staged},
+			rollout(1), false, ""},
+		{"increase halted", []*ap.TrackRelease{completed, halted},
+			rollout(0.5), false, ""},
+		{"no staged rollout", []*ap.TrackRelease{completed},
+			rollout(0.5), false, ""},
+		{"halt", []*ap.TrackRelease{completed, staged}, halt,
+			false, "completed [1] 0; halted [2] 0.1"},
+		{"halt halted", []*ap.TrackRelease{completed, halted}, halt,
+			false, ""},
+		{"halt completed", []*ap.TrackRelease{completed}, halt, false, ""},
+		{"resume", []*ap.TrackRelease{completed, halted}, resume,
+			false, "completed [1] 0; inProgress [2] 0.1"},
+		{"resume in progress", []*ap.TrackRelease{completed, staged},
+			resume, false, ""},
+		{"complete", []*ap.TrackRelease{completed, staged, draft},
+			complete, false, "completed [2] 0; draft [3] 0"},
+		{"complete halted", []*ap.TrackRelease{completed, halted},
+			complete, false, "completed [2] 0"},
+		{"complete dry run", []*ap.TrackRelease{completed, staged, draft},
+			complete, true,
+			"completed [1] 0; inProgress [2] 0.1; draft [3] 0"},
+		{"complete completed", []*ap.TrackRelease{completed}, complete,
+			false, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			app := releaseTestApp()
+			app.Tracks = map[string]*ap.Track{"production": {
+				Track: "production", Releases: test.releases,
+			}}
+			server, pub := newTestServer(t, app)
+			result, err := test.change(pub, test.dryRun)
+			if got := server.OpenEdits(); got != 0 {
+				t.Errorf("%d open edits, want 0", got)
+			}
+			wantCommits := 1
+			if test.want == "" || test.dryRun {
+				wantCommits = 0
+			}
+			if got := server.Commits(testPackage); got != wantCommits {
+				t.Errorf("%d commits, want %d", got, wantCommits)
+			}
+			if test.want == "" {
+				if err == nil {
+					t.Errorf("change got %+v, want an error", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("change got %v", err)
+			}
+			if result.Committed == test.dryRun {
+				t.Errorf("result committed %v, dry run %v",
+					result.Committed, test.dryRun)
+			}
+			track := server.App(testPackage).Tracks["production"]
+			if got := releasesString(track.Releases); got != test.want {
+				t.Errorf("releases %q, want %q", got, test.want)
+			}
+		})
+	}
+}
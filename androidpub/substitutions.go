@@ -0,0 +1,271 @@
+// substitutions.go
+// Contains reading of translation substitution files.  A substitution is an
+// alternate default language text that is translated instead of the
+// original when the translation of the original is too long.
+package androidpub
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// localeTag matches a BCP-47 locale like de-DE, es-419 or zh-Hans-CN.  The
+// language is lower case, as the Play Store has it, so words like Tip
+// aren't taken for one.
+var localeTag = regexp.MustCompile(
+	`^[a-z]{2,3}(?:-(?:[A-Za-z]{2}|[0-9]{3}|[A-Za-z]{4}|[A-Za-z0-9]{5,8}))*$`)
+
+// substitution is an alternate for an original text.  An empty Locale or
+// Field means it is used for all of them.
+type substitution struct {
+	Locale    string // BCP-47 locale.
+	Field     string // title, shortDescription or fullDescription.
+	Original  string
+	Alternate string
+}
+
+// substitutions are all the alternates from a substitution file.
+type substitutions []substitution
+
+// alternate returns the alternate for the original text of a field in a
+// locale or "" if there isn't one.  The most specific alternate is used,
+// locale and field, then field, then locale and then one for everything.
+func (subs substitutions) alternate(bcp47, field, original string) string {
+	best, bestScore := "", 0
+	for _, sub := range subs {
+		if sub.Original != original ||
+			(sub.Locale != "" && sub.Locale != bcp47) ||
+			(sub.Field != "" && sub.Field != field) {
+			continue
+		}
+		score := 1
+		if sub.Locale != "" {
+			score += 1
+		}
+		if sub.Field != "" {
+			score += 2
+		}
+		if score > bestScore {
+			best, bestScore = sub.Alternate, score
+		}
+	}
+	return best
+}
+
+// isLocale is true if name is a BCP-47 locale.
+func isLocale(name string) bool {
+	return localeTag.MatchString(name)
+}
+
+// isField is true if name is a listing field name.
+func isField(name string) bool {
+	switch name {
+	case fieldTitle, fieldShortDescription, fieldFullDescription:
+		return true
+	}
+	return false
+}
+
+// readSubstitutions reads a translation substitution file.  Files ending in
+// .yaml or .yml are YAML, anything else is the older line format.  If there
+// is not a file it prints a warning and returns no substitutions.
+func readSubstitutions(subFile string) (substitutions, error) {
+	bytes, err := ioutil.ReadFile(subFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: no substitution file '%s'\n", subFile)
+		return nil, nil
+	}
+	return parseSubstitutions(subFile, bytes)
+}
+
+// parseSubstitutions parses the substitution file contents using the format
+// for its extension.
+func parseSubstitutions(subFile string, bytes []byte) (substitutions, error) {
+	switch strings.ToLower(filepath.Ext(subFile)) {
+	case ".yaml", ".yml":
+		return parseYamlSubstitutions(subFile, bytes)
+	}
+	return parseLineSubstitutions(subFile, bytes)
+}
+
+// parseYamlSubstitutions parses a YAML substitution file.  It is a list of
+// substitutions, locale and field are optional.
+//
+//	# Comments are allowed.
+//	- original: "Note: easy to use"
+//	  alternate: Easy
+//	- locale: de-DE
+//	  field: shortDescription
+//	  original: |
+//	    A multiline
+//	    original.
+//	  alternate: Shorter
+//
+// A final new line, as | adds, is removed from original and alternate.
+func parseYamlSubstitutions(
+	subFile string, bytes []byte) (substitutions, error) {
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(bytes, &doc); err != nil {
+		return nil, fmt.Errorf("%s %v", subFile, err)
+	}
+	if len(doc.Content) == 0 {
+		// Empty file.
+		return nil, nil
+	}
+	list := doc.Content[0]
+	if list.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("%s:%d: substitutions must be a list",
+			subFile, list.Line)
+	}
+	var subs substitutions
+	for _, item := range list.Content {
+		if item.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("%s:%d: substitution must be a mapping",
+				subFile, item.Line)
+		}
+		var sub substitution
+		for i := 0; i+1 < len(item.Content); i += 2 {
+			key, value := item.Content[i], item.Content[i+1]
+			if value.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("%s:%d: %s must be text",
+					subFile, value.Line, key.Value)
+			}
+			text := strings.TrimRight(value.Value, "\n")
+			switch key.Value {
+			case "locale":
+				sub.Locale = text
+			case "field":
+				if !isField(text) {
+					return nil, fmt.Errorf("%s:%d: bad field '%s'",
+						subFile, value.Line, text)
+				}
+				sub.Field = text
+			case "original":
+				sub.Original = text
+			case "alternate":
+				sub.Alternate = text
+			default:
+				return nil, fmt.Errorf("%s:%d: unknown key '%s'",
+					subFile, key.Line, key.Value)
+			}
+		}
+		if sub.Original == "" || sub.Alternate == "" {
+			return nil, fmt.Errorf("%s:%d: substitution needs original and alternate",
+				subFile, item.Line)
+		}
+		subs = append(subs, sub)
+	}
+	return subs, nil
+}
+
+// parseLineSubstitutions parses the older line substitution format.  Each
+// line is either:
+//
+//	original: alternate
+//	scope: original: alternate
+//
+// Where scope is LOCALE/FIELD, FIELD or LOCALE.  For instance
+// de-DE/shortDescription, shortDescription or de-DE.  Blank lines are
+// skipped.  Other lines with colons are errors, YAML is needed for text
+// with colons.
+func parseLineSubstitutions(
+	subFile string, bytes []byte) (substitutions, error) {
+
+	var subs substitutions
+	scanner := bufio.NewScanner(strings.NewReader(string(bytes)))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			// Blank line.
+			continue
+		}
+		toks := strings.Split(line, ":")
+		for i := range toks {
+			toks[i] = strings.TrimSpace(toks[i])
+		}
+		var sub substitution
+		switch len(toks) {
+		case 2:
+			// Original to alternate.
+			sub = substitution{Original: toks[0], Alternate: toks[1]}
+		case 3:
+			sub = substitution{Original: toks[1], Alternate: toks[2]}
+			scope := strings.SplitN(toks[0], "/", 2)
+			switch {
+			case len(scope) == 2 && isLocale(scope[0]):
+				sub.Locale, sub.Field = scope[0], scope[1]
+				if !isField(sub.Field) {
+					return nil, fmt.Errorf("%s:%d: bad field '%s'",
+						subFile, n, sub.Field)
+				}
+			case len(scope) == 1 && isField(scope[0]):
+				sub.Field = scope[0]
+			case len(scope) == 1 && isLocale(scope[0]):
+				sub.Locale = scope[0]
+			default:
+				// Text with a colon, like "Note: Long title: Short".
+				return nil, badLineSubstitution(subFile, n, line)
+			}
+		default:
+			return nil, badLineSubstitution(subFile, n, line)
+		}
+		subs = append(subs, sub)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s got %v", subFile, err)
+	}
+	return subs, nil
+}
+
+// badLineSubstitution is the error for a line that isn't a substitution.
+func badLineSubstitution(subFile string, n int, line string) error {
+	return fmt.Errorf("%s:%d: bad substitution '%s', use YAML for text with colons",
+		subFile, n, line)
+}
+
+// ConvertSubstitutions writes the substitutions in subFile, in either
+// format, as YAML.
+func ConvertSubstitutions(w io.Writer, subFile string) error {
+	bytes, err := ioutil.ReadFile(subFile)
+	if err != nil {
+		return fmt.Errorf("reading %s got %v", subFile, err)
+	}
+	subs, err := parseSubstitutions(subFile, bytes)
+	if err != nil {
+		return err
+	}
+	type yamlSubstitution struct {
+		Locale    string `yaml:"locale,omitempty"`
+		Field     string `yaml:"field,omitempty"`
+		Original  string `yaml:"original"`
+		Alternate string `yaml:"alternate"`
+	}
+	list := make([]yamlSubstitution, len(subs))
+	for i, sub := range subs {
+		list[i] = yamlSubstitution(sub)
+	}
+	fmt.Fprintf(w, "# Substitutions from %s\n", subFile)
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(list); err != nil {
+		return fmt.Errorf("encoding %s got %v", subFile, err)
+	}
+	return enc.Close()
+}
+
+// printAlternates reports the alternates that were used to w.
+func printAlternates(w io.Writer, used []substitution) {
+	for _, sub := range used {
+		fmt.Fprintf(w, "%s %s used alternate '%s' for '%s'\n",
+			sub.Locale, sub.Field, sub.Alternate, sub.Original)
+	}
+}
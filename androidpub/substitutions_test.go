@@ -0,0 +1,212 @@
+// substitutions_test.go
+// Tests parsing substitution files and picking alternates.
+package androidpub
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLineSubstitutions(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		want  substitutions
+		isErr bool
+	}{
+		{
+			name: "empty",
+			text: "",
+		},
+		{
+			name: "original and alternate",
+			text: "Easy to use: Easy\n\n  Fast : Quick  \n",
+			want: substitutions{
+				{Original: "Easy to use", Alternate: "Easy"},
+				{Original: "Fast", Alternate: "Quick"},
+			},
+		},
+		{
+			name: "scopes",
+			text: "de-DE/title: Easy: E\n" +
+				"shortDescription: Easy: S\n" +
+				"fr-FR: Easy: F\n" +
+				"es-419/fullDescription: Easy: L\n",
+			want: substitutions{
+				{Locale: "de-DE", Field: "title",
+					Original: "Easy", Alternate: "E"},
+				{Field: "shortDescription", Original: "Easy", Alternate: "S"},
+				{Locale: "fr-FR", Original: "Easy", Alternate: "F"},
+				{Locale: "es-419", Field: "fullDescription",
+					Original: "Easy", Alternate: "L"},
+			},
+		},
+		{
+			name:  "bad field",
+			text:  "de-DE/subtitle: Easy: E\n",
+			isErr: true,
+		},
+		{
+			name:  "no alternate",
+			text:  "Easy\n",
+			isErr: true,
+		},
+		{
+			name:  "colon in original",
+			text:  "Note: Long title: Short\n",
+			isErr: true,
+		},
+		{
+			name:  "colon in field scoped original",
+			text:  "title/de-DE: Long: Short\n",
+			isErr: true,
+		},
+		{
+			name:  "too many colons",
+			text:  "de-DE: Note: easy: Easy\n",
+			isErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseLineSubstitutions("sub", []byte(test.text))
+			if test.isErr {
+				if err == nil {
+					t.Errorf("parseLineSubstitutions got %+v, want an error",
+						got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseLineSubstitutions got %v", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseLineSubstitutions got %+v, want %+v",
+					got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseYamlSubstitutions(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		want  substitutions
+		isErr bool
+	}{
+		{
+			name: "empty",
+			text: "# Nothing yet.\n",
+		},
+		{
+			name: "colons and multiline",
+			text: "# Comment.\n" +
+				"- original: \"Note: easy to use\"\n" +
+				"  alternate: Easy\n" +
+				"- locale: de-DE\n" +
+				"  field: shortDescription\n" +
+				"  original: |\n" +
+				"    A multiline\n" +
+				"    original.\n" +
+				"  alternate: Shorter\n",
+			want: substitutions{
+				{Original: "Note: easy to use", Alternate: "Easy"},
+				{Locale: "de-DE", Field: "shortDescription",
+					Original: "A multiline\noriginal.", Alternate: "Shorter"},
+			},
+		},
+		{
+			name:  "not a list",
+			text:  "original: Easy\nalternate: E\n",
+			isErr: true,
+		},
+		{
+			name:  "not a mapping",
+			text:  "- Easy\n",
+			isErr: true,
+		},
+		{
+			name:  "bad field",
+			text:  "- field: subtitle\n  original: Easy\n  alternate: E\n",
+			isErr: true,
+		},
+		{
+			name:  "unknown key",
+			text:  "- original: Easy\n  alternative: E\n",
+			isErr: true,
+		},
+		{
+			name:  "no alternate",
+			text:  "- original: Easy\n",
+			isErr: true,
+		},
+		{
+			name:  "not text",
+			text:  "- original: [Easy]\n  alternate: E\n",
+			isErr: true,
+		},
+		{
+			name:  "bad YAML",
+			text:  "- original: \"Easy\n",
+			isErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseYamlSubstitutions("sub.yaml", []byte(test.text))
+			if test.isErr {
+				if err == nil {
+					t.Errorf("parseYamlSubstitutions got %+v, want an error",
+						got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseYamlSubstitutions got %v", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseYamlSubstitutions got %+v, want %+v",
+					got, test.want)
+			}
+		})
+	}
+}
+
+func TestSubstitutionsAlternate(t *testing.T) {
+	all := substitution{Original: "Easy", Alternate: "all"}
+	locale := substitution{Locale: "de-DE",
+		Original: "Easy", Alternate: "locale"}
+	field := substitution{Field: "title", Original: "Easy", Alternate: "field"}
+	both := substitution{Locale: "de-DE", Field: "title",
+		Original: "Easy", Alternate: "both"}
+	tests := []struct {
+		name           string
+		subs           substitutions
+		bcp47, field   string
+		original, want string
+	}{
+		{"none", nil, "de-DE", "title", "Easy", ""},
+		{"other original", substitutions{all}, "de-DE", "title", "Hard", ""},
+		{"all", substitutions{all}, "de-DE", "title", "Easy", "all"},
+		{"locale over all", substitutions{all, locale},
+			"de-DE", "title", "Easy", "locale"},
+		{"field over locale", substitutions{locale, field, all},
+			"de-DE", "title", "Easy", "field"},
+		{"both over field", substitutions{field, both, locale},
+			"de-DE", "title", "Easy", "both"},
+		{"other locale", substitutions{both, locale, all},
+			"fr-FR", "title", "Easy", "all"},
+		{"other field", substitutions{both, field, locale},
+			"de-DE", "shortDescription", "Easy", "locale"},
+		{"first of equals", substitutions{all,
+			{Original: "Easy", Alternate: "second"}},
+			"de-DE", "title", "Easy", "all"},
+	}
+	for _, test := range tests {
+		got := test.subs.alternate(test.bcp47, test.field, test.original)
+		if got != test.want {
+			t.Errorf("%s: alternate got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,436 @@
+// update_test.go
+// Tests PackageUpdate end to end against the fakeplay server.
+package androidpub
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/napcatstudio/androidpubtools/androidpub/fakeplay"
+	ap "google.golang.org/api/androidpublisher/v3"
+)
+
+const testPackage = "com.example.app"
+
+// newTestServer starts a fakeplay server with an en-US app and returns a
+// Publisher for it.
+func newTestServer(
+	t *testing.T, app *fakeplay.App) (*fakeplay.Server, Publisher) {
+
+	t.Helper()
+	server := fakeplay.New()
+	t.Cleanup(server.Close)
+	if app == nil {
+		app = &fakeplay.App{}
+	}
+	if app.Details.DefaultLanguage == "" {
+		app.Details.DefaultLanguage = "en-US"
+	}
+	if app.Listings == nil {
+		app.Listings = map[string]*ap.Listing{
+			"en-US": {Language: "en-US", Title: "Example"},
+		}
+	}
+	server.SetApp(testPackage, app)
+	pub, err := GetPublisher(
+		context.Background(), "", server.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("GetPublisher got %v", err)
+	}
+	return server, pub
+}
+
+// writeTestPng writes a 640x360 phone screenshot of a single shade and
+// returns its SHA1.
+func writeTestPng(t *testing.T, file string, shade uint8) string {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 640, 360))
+	for y := 0; y < 360; y++ {
+		for x := 0; x < 640; x++ {
+			img.Set(x, y, color.RGBA{shade, shade, shade, 0xff})
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fmt.Sprintf("%x", sha1.Sum(data))
+}
+
+// writeScreenshots writes en-US phone screenshots of the shades into the
+// images directory and returns their SHA1s in order.
+func writeScreenshots(
+	t *testing.T, imagesDir string, shades ...uint8) []string {
+
+	t.Helper()
+	var sums []string
+	for i, shade := range shades {
+		file := filepath.Join(
+			imagesDir, "phoneScreenshots", fmt.Sprintf("en-US_%d.png", i))
+		sums = append(sums, writeTestPng(t, file, shade))
+	}
+	return sums
+}
+
+// liveScreenshots returns the SHA1s of the live en-US phone screenshots.
+func liveScreenshots(server *fakeplay.Server) []string {
+	var sums []string
+	images := server.App(testPackage).Images["en-US"]["phoneScreenshots"]
+	for _, image := range images {
+		sums = append(sums, image.Sha1)
+	}
+	return sums
+}
+
+// imageUpdateOptions are the options to update the images from imagesDir.
+func imageUpdateOptions(imagesDir string) UpdateOptions {
+	return UpdateOptions{
+		ImagesDir: imagesDir,
+		DoImages:  true,
+		Progress:  ioutil.Discard,
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestUpdateText(t *testing.T) {
+	server, pub := newTestServer(t, nil)
+	metadataDir := t.TempDir()
+	err := writeFastlaneListing(metadataDir, &ap.Listing{
+		Language:         "en-US",
+		Title:            "Example App",
+		ShortDescription: "An example.",
+		FullDescription:  "An example app.",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := PackageUpdate(context.Background(), pub, testPackage,
+		UpdateOptions{
+			DoText:      true,
+			Format:      FormatFastlane,
+			MetadataDir: metadataDir,
+			Progress:    ioutil.Discard,
+		})
+	if err != nil {
+		t.Fatalf("PackageUpdate got %v", err)
+	}
+	if !result.Committed {
+		t.Errorf("result not committed")
+	}
+	if len(result.Actions) != 1 ||
+		result.Actions[0].Kind != ActionUpdateListing {
+		t.Errorf("actions %+v, want one %s", result.Actions,
+			ActionUpdateListing)
+	}
+	listing := server.App(testPackage).Listings["en-US"]
+	if listing.Title != "Example App" ||
+		listing.ShortDescription != "An example." ||
+		listing.FullDescription != "An example app." {
+		t.Errorf("listing %+v not updated", listing)
+	}
+	if got := server.Commits(testPackage); got != 1 {
+		t.Errorf("%d commits, want 1", got)
+	}
+	if got := server.OpenEdits(); got != 0 {
+		t.Errorf("%d open edits, want 0", got)
+	}
+
+	// Nothing to change, nothing to commit.
+	result, err = PackageUpdate(context.Background(), pub, testPackage,
+		UpdateOptions{
+			DoText:      true,
+			Format:      FormatFastlane,
+			MetadataDir: metadataDir,
+			Progress:    ioutil.Discard,
+		})
+	if err != nil {
+		t.Fatalf("PackageUpdate again got %v", err)
+	}
+	if result.Committed || len(result.Actions) != 0 {
+		t.Errorf("unchanged update got %+v", result)
+	}
+	if got := server.Commits(testPackage); got != 1 {
+		t.Errorf("%d commits after unchanged update, want 1", got)
+	}
+	if got := server.OpenEdits(); got != 0 {
+		t.Errorf("%d open edits after unchanged update, want 0", got)
+	}
+}
+
+func TestUpdateImages(t *testing.T) {
+	tests := []struct {
+		name string
+		live []uint8 // Shades of the live screenshots.
+		want []uint8 // Shades of the local screenshots.
+		// kinds are how many actions of each kind are wanted.
+		kinds map[string]int
+	}{
+		{
+			name:  "add",
+			want:  []uint8{10, 20},
+			kinds: map[string]int{ActionUploadImage: 2},
+		},
+		{
+			name:  "append",
+			live:  []uint8{10, 20},
+			want:  []uint8{10, 20, 30},
+			kinds: map[string]int{ActionUploadImage: 1},
+		},
+		{
+			name:  "delete",
+			live:  []uint8{10, 20, 30},
+			want:  []uint8{10, 20},
+			kinds: map[string]int{ActionDeleteImage: 1},
+		},
+		{
+			name: "reorder",
+			live: []uint8{10, 20, 30},
+			want: []uint8{10, 30, 20},
+			kinds: map[string]int{
+				ActionReorderImages: 1,
+				ActionDeleteImage:   1,
+				ActionUploadImage:   1,
+			},
+		},
+		{
+			name: "replace all",
+			live: []uint8{10, 20, 30},
+			want: []uint8{40, 50, 60},
+			kinds: map[string]int{
+				ActionDeleteAllImages: 1,
+				ActionUploadImage:     3,
+			},
+		},
+		{
+			name:  "same",
+			live:  []uint8{10, 20},
+			want:  []uint8{10, 20},
+			kinds: map[string]int{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, pub := newTestServer(t, nil)
+			imagesDir := t.TempDir()
+			if len(test.live) != 0 {
+				liveDir := t.TempDir()
+				writeScreenshots(t, liveDir, test.live...)
+				for i := range test.live {
+					data, err := ioutil.ReadFile(filepath.Join(liveDir,
+						"phoneScreenshots", fmt.Sprintf("en-US_%d.png", i)))
+					if err != nil {
+						t.Fatal(err)
+					}
+					server.AddImage(
+						testPackage, "en-US", "phoneScreenshots", data)
+				}
+			}
+			want := writeScreenshots(t, imagesDir, test.want...)
+			commits := server.Commits(testPackage)
+
+			result, err := PackageUpdate(context.Background(), pub,
+				testPackage, imageUpdateOptions(imagesDir))
+			if err != nil {
+				t.Fatalf("PackageUpdate got %v", err)
+			}
+			kinds := make(map[string]int)
+			for _, action := range result.Actions {
+				kinds[action.Kind]++
+			}
+			if fmt.Sprint(kinds) != fmt.Sprint(test.kinds) {
+				t.Errorf("actions %v, want %v", kinds, test.kinds)
+			}
+			if got := liveScreenshots(server); !equalStrings(got, want) {
+				t.Errorf("live screenshots %v, want %v", got, want)
+			}
+			wantCommits := commits
+			if len(test.kinds) != 0 {
+				wantCommits++
+			}
+			if got := server.Commits(testPackage); got != wantCommits {
+				t.Errorf("%d commits, want %d", got, wantCommits)
+			}
+			if got := server.OpenEdits(); got != 0 {
+				t.Errorf("%d open edits, want 0", got)
+			}
+		})
+	}
+}
+
+func TestUpdateDryRun(t *testing.T) {
+	server, pub := newTestServer(t, nil)
+	imagesDir := t.TempDir()
+	writeScreenshots(t, imagesDir, 10, 20)
+
+	opts := imageUpdateOptions(imagesDir)
+	opts.DryRun = true
+	result, err := PackageUpdate(context.Background(), pub, testPackage, opts)
+	if err != nil {
+		t.Fatalf("PackageUpdate got %v", err)
+	}
+	if !result.DryRun || result.Committed {
+		t.Errorf("dry run result %+v", result)
+	}
+	if len(result.Actions) != 2 {
+		t.Errorf("%d actions, want 2 uploads", len(result.Actions))
+	}
+	if got := liveScreenshots(server); len(got) != 0 {
+		t.Errorf("dry run uploaded %v", got)
+	}
+	if got := server.Commits(testPackage); got != 0 {
+		t.Errorf("%d commits, want 0", got)
+	}
+	if got := server.OpenEdits(); got != 0 {
+		t.Errorf("%d open edits, want 0", got)
+	}
+}
+
+func TestUpdateCommitFailureDeletesEdit(t *testing.T) {
+	// Managed publishing apps can't be sent for review.
+	server, pub := newTestServer(t, &fakeplay.App{ManagedPublishing: true})
+	imagesDir := t.TempDir()
+	writeScreenshots(t, imagesDir, 10, 20)
+
+	_, err := PackageUpdate(context.Background(), pub, testPackage,
+		imageUpdateOptions(imagesDir))
+	if err == nil {
+		t.Fatalf("PackageUpdate of a managed publishing app worked")
+	}
+	if got := liveScreenshots(server); len(got) != 0 {
+		t.Errorf("failed commit uploaded %v", got)
+	}
+	if got := server.Commits(testPackage); got != 0 {
+		t.Errorf("%d commits, want 0", got)
+	}
+	if got := server.OpenEdits(); got != 0 {
+		t.Errorf("%d open edits, want 0", got)
+	}
+
+	opts := imageUpdateOptions(imagesDir)
+	opts.NotSentForReview = true
+	result, err := PackageUpdate(context.Background(), pub, testPackage, opts)
+	if err != nil {
+		t.Fatalf("PackageUpdate not sent for review got %v", err)
+	}
+	if !result.Committed || !result.NotSentForReview {
+		t.Errorf("not sent for review result %+v", result)
+	}
+	if got := server.Commits(testPackage); got != 1 {
+		t.Errorf("%d commits, want 1", got)
+	}
+}
+
+// cancelPublisher cancels the context when an image is uploaded, part way
+// through an update.
+type cancelPublisher struct {
+	Publisher
+	cancel context.CancelFunc
+}
+
+func (p *cancelPublisher) UploadImage(
+	ctx context.Context,
+	packageName, editId, language, imageType, file string) (*ap.Image, error) {
+
+	p.cancel()
+	return p.Publisher.UploadImage(
+		ctx, packageName, editId, language, imageType, file)
+}
+
+func TestUpdateCancelledDeletesEdit(t *testing.T) {
+	server, pub := newTestServer(t, nil)
+	imagesDir := t.TempDir()
+	writeScreenshots(t, imagesDir, 10, 20)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pub = &cancelPublisher{Publisher: pub, cancel: cancel}
+	_, err := PackageUpdate(
+		ctx, pub, testPackage, imageUpdateOptions(imagesDir))
+	if err == nil {
+		t.Fatalf("cancelled PackageUpdate worked")
+	}
+	if got := server.Commits(testPackage); got != 0 {
+		t.Errorf("%d commits, want 0", got)
+	}
+	if got := server.OpenEdits(); got != 0 {
+		t.Errorf("%d open edits, want 0", got)
+	}
+}
+
+func TestUpdateFastlaneImagesSkipsMissingLocale(t *testing.T) {
+	server, pub := newTestServer(t, &fakeplay.App{
+		Listings: map[string]*ap.Listing{
+			"en-US": {Language: "en-US", Title: "Example"},
+			"de-DE": {Language: "de-DE", Title: "Beispiel"},
+		},
+	})
+	liveDir := t.TempDir()
+	writeScreenshots(t, liveDir, 30, 40)
+	for i := 0; i < 2; i++ {
+		data, err := ioutil.ReadFile(filepath.Join(liveDir,
+			"phoneScreenshots", fmt.Sprintf("en-US_%d.png", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		server.AddImage(testPackage, "de-DE", "phoneScreenshots", data)
+	}
+	// Only en-US is kept in fastlane.
+	metadataDir := t.TempDir()
+	screenshots := filepath.Join(
+		metadataDir, "en-US", fastlaneImages, "phoneScreenshots")
+	want := []string{
+		writeTestPng(t, filepath.Join(screenshots, "1_en-US.png"), 10),
+		writeTestPng(t, filepath.Join(screenshots, "2_en-US.png"), 20),
+	}
+
+	_, err := PackageUpdate(context.Background(), pub, testPackage,
+		UpdateOptions{
+			DoImages:    true,
+			Format:      FormatFastlane,
+			MetadataDir: metadataDir,
+			Progress:    ioutil.Discard,
+		})
+	if err != nil {
+		t.Fatalf("PackageUpdate got %v", err)
+	}
+	if got := liveScreenshots(server); !equalStrings(got, want) {
+		t.Errorf("live en-US screenshots %v, want %v", got, want)
+	}
+	images := server.App(testPackage).Images["de-DE"]["phoneScreenshots"]
+	if len(images) != 2 {
+		t.Errorf("%d live de-DE screenshots, want 2 left alone", len(images))
+	}
+}
@@ -0,0 +1,274 @@
+// validate.go
+// Contains checks of listing text and images against the Play Store rules.
+// They are run on every listing before any of them is changed so a bad
+// translation or image can't leave a half updated edit.
+package androidpub
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	xlns "github.com/napcatstudio/translate/v2"
+	"github.com/rivo/uniseg"
+
+	ap "google.golang.org/api/androidpublisher/v3"
+)
+
+// Listing field names.
+const (
+	fieldTitle            = "title"
+	fieldShortDescription = "shortDescription"
+	fieldFullDescription  = "fullDescription"
+)
+
+// Play Store listing limits in characters (grapheme clusters).
+const (
+	maxTitleLength            = 30
+	maxShortDescriptionLength = 80
+	maxFullDescriptionLength  = 4000
+)
+
+// ListingProblem is something wrong with a listing field.
+type ListingProblem struct {
+	Language string // BCP-47 locale.
+	Field    string // title, shortDescription or fullDescription.
+	Problem  string
+}
+
+func (p ListingProblem) String() string {
+	return fmt.Sprintf("%s %s %s", p.Language, p.Field, p.Problem)
+}
+
+// ListingProblems is an error made from all the problems found in a set of
+// listings.
+type ListingProblems []ListingProblem
+
+func (ps ListingProblems) Error() string {
+	lines := make([]string, len(ps))
+	for i, p := range ps {
+		lines[i] = p.String()
+	}
+	return fmt.Sprintf("%d listing problems:\n%s",
+		len(ps), strings.Join(lines, "\n"))
+}
+
+// ValidateListing checks a listing for length limits, empty fields and
+// disallowed characters.  If base, the default language listing, is given
+// and the listing is for a different language then descriptions that are
+// the same as the base are reported as untranslated.
+func ValidateListing(listing, base *ap.Listing) []ListingProblem {
+	fields := []struct {
+		name, text, baseText string
+		max                  int
+		multiline            bool
+	}{
+		{fieldTitle, listing.Title, "", maxTitleLength, false},
+		{fieldShortDescription, listing.ShortDescription, "",
+			maxShortDescriptionLength, false},
+		{fieldFullDescription, listing.FullDescription, "",
+			maxFullDescriptionLength, true},
+	}
+	if base != nil && isDifferentLanguage(base.Language, listing.Language) {
+		// Titles are often names so they can stay the same.
+		fields[1].baseText = base.ShortDescription
+		fields[2].baseText = base.FullDescription
+	}
+
+	var problems []ListingProblem
+	add := func(field, format string, a ...interface{}) {
+		problems = append(problems, ListingProblem{
+			Language: listing.Language,
+			Field:    field,
+			Problem:  fmt.Sprintf(format, a...),
+		})
+	}
+	for _, f := range fields {
+		if strings.TrimSpace(f.text) == "" {
+			add(f.name, "is empty")
+			continue
+		}
+		if n := uniseg.GraphemeClusterCount(f.text); n > f.max {
+			add(f.name, "is %d characters, more than %d", n, f.max)
+		}
+		if r, bad := disallowedRune(f.text, f.multiline); bad {
+			add(f.name, "has disallowed character %U", r)
+		}
+		if f.baseText != "" && f.text == f.baseText {
+			add(f.name, "is untranslated")
+		}
+	}
+	return problems
+}
+
+// validateListings checks all the listings against the base listing and
+// returns all the problems as a single error.
+func validateListings(listings []*ap.Listing, base *ap.Listing) error {
+	var problems ListingProblems
+	for _, listing := range listings {
+		problems = append(problems, ValidateListing(listing, base)...)
+	}
+	if len(problems) != 0 {
+		return problems
+	}
+	return nil
+}
+
+// disallowedRune finds the first character the Play Store won't accept.
+// Control characters are not allowed except for new lines and tabs in
+// multiline text.  The Unicode replacement character is not allowed as it
+// means text was badly decoded.
+func disallowedRune(text string, multiline bool) (rune, bool) {
+	for _, r := range text {
+		switch {
+		case r == unicode.ReplacementChar:
+			return r, true
+		case (r == '\n' || r == '\t') && multiline:
+			continue
+		case unicode.IsControl(r):
+			return r, true
+		}
+	}
+	return 0, false
+}
+
+// isDifferentLanguage is true if two BCP-47 locales have different
+// languages.
+func isDifferentLanguage(bcp47a, bcp47b string) bool {
+	return xlns.Iso639FromBcp47(bcp47a) != xlns.Iso639FromBcp47(bcp47b)
+}
+
+// PackageValidate checks, without using the Play Store, the listings a text
+// update would make.  For FormatWords the default language listing, and the
+// locales, come from a pulled listingsDir and are translated using the
+// words files.  For FormatFastlane the fastlane text files are checked.  If
+// langs are given only those locales are checked.  Only the text fields and
+// Progress of opts are used.
+func PackageValidate(listingsDir string, opts UpdateOptions) error {
+	if opts.Progress == nil {
+		opts.Progress = os.Stderr
+	}
+	var base *ap.Listing
+	var checks []*ap.Listing
+	if opts.Format == FormatFastlane {
+		locales, err := FastlaneLocales(opts.MetadataDir)
+		if err != nil {
+			return err
+		}
+		for _, bcp47 := range locales {
+			listing := &ap.Listing{Language: bcp47}
+			if !useListing(opts.Langs, listing) {
+				continue
+			}
+			listing, err = readFastlaneListing(opts.MetadataDir, listing)
+			if err != nil {
+				return err
+			}
+			checks = append(checks, listing)
+		}
+	} else {
+		subs, err := readSubstitutions(opts.SubFile)
+		if err != nil {
+			return err
+		}
+		var details ap.AppDetails
+		err = readJson(filepath.Join(listingsDir, detailsFile), &details)
+		if err != nil {
+			return err
+		}
+		defBcp47 := details.DefaultLanguage
+		base = &ap.Listing{}
+		err = readJson(filepath.Join(listingsDir, defBcp47+listingExt), base)
+		if err != nil {
+			return err
+		}
+		locales, err := pulledLocales(listingsDir)
+		if err != nil {
+			return err
+		}
+		var used []substitution
+		for _, bcp47 := range locales {
+			listing := &ap.Listing{Language: bcp47}
+			if bcp47 == defBcp47 || !useListing(opts.Langs, listing) {
+				continue
+			}
+			var alts []substitution
+			listing, alts, err = translateListing(
+				opts.WordsDir, base, bcp47, subs)
+			if err != nil {
+				return err
+			}
+			used = append(used, alts...)
+			checks = append(checks, listing)
+		}
+		printAlternates(opts.Progress, used)
+	}
+	if err := validateListings(checks, base); err != nil {
+		return err
+	}
+	fmt.Fprintf(opts.Progress, "%d listings ok\n", len(checks))
+	return nil
+}
+
+// PackageCheckImages checks, without using the Play Store, the image files
+// an images update would upload against the GooglePlayImageSpecs.  For
+// FormatWords the default language, and the locales, come from a pulled
+// listingsDir.  For FormatFastlane the fastlane locales are checked.  If
+// langs are given only those locales are checked.  Only the image fields and
+// Progress of opts are used.
+func PackageCheckImages(listingsDir string, opts UpdateOptions) error {
+	if opts.Progress == nil {
+		opts.Progress = os.Stderr
+	}
+	imagesDir := opts.ImagesDir
+	defBcp47 := ""
+	var locales []string
+	var err error
+	if opts.Format == FormatFastlane {
+		imagesDir = opts.MetadataDir
+		locales, err = FastlaneLocales(opts.MetadataDir)
+	} else {
+		var details ap.AppDetails
+		err = readJson(filepath.Join(listingsDir, detailsFile), &details)
+		if err != nil {
+			return err
+		}
+		defBcp47 = details.DefaultLanguage
+		locales, err = pulledLocales(listingsDir)
+	}
+	if err != nil {
+		return err
+	}
+	var checks []string
+	for _, bcp47 := range locales {
+		if useListing(opts.Langs, &ap.Listing{Language: bcp47}) {
+			checks = append(checks, bcp47)
+		}
+	}
+	err = checkLocalImages(imagesDir, opts.Format, defBcp47, checks)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(opts.Progress, "images ok for %d locales\n", len(checks))
+	return nil
+}
+
+// pulledLocales returns the locales of the listings in a pulled listings
+// directory.
+func pulledLocales(listingsDir string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(listingsDir, "*"+listingExt))
+	if err != nil {
+		return nil, err
+	}
+	var locales []string
+	for _, match := range matches {
+		name := filepath.Base(match)
+		if name == detailsFile {
+			continue
+		}
+		locales = append(locales, strings.TrimSuffix(name, listingExt))
+	}
+	return locales, nil
+}
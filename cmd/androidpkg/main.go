@@ -4,39 +4,132 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 
 	apt "github.com/napcatstudio/androidpubtools/androidpub"
+	ap "google.golang.org/api/androidpublisher/v3"
 )
 
 const (
 	defaultCredentials = "credentials.json"
 	defaultWordsDir    = "words"
 	defaultImagesDir   = "images"
+	defaultListingsDir = "listings"
+	defaultMetadataDir = "metadata/android"
 	defaultUpdateSub   = "update.sub"
+	defaultTrack       = "internal"
+	defaultRollout     = "production"
+	defaultStatus      = "completed"
 	USAGE              = `androidpkg is a tool for managing Play Store packages.
 
 It can update the Play Store country text and images.  It uses a meaning
 ordered words system for text.  It uses a directory hierarchy for images.
 If a text translation is too long it used the 'sub' file, if provided, for
-alternative translation text.
+alternative translation text.  A 'sub' file ending in .yaml or .yml is YAML.
 
 Usage:
 	androidpkg [flags..] command packageName [lang..]
 
 The commands are:
 	info
-	  Lookup information about packageName.
+	  Lookup information about packageName.  The images of each listing
+	  locale are listed with a coverage table of the image counts, marking
+	  the types that fall back to the default language and the types below
+	  the Play minimum.
 	update
-	  Update packageName images and text.
+	  Update packageName images and text.  If -bundle or -version-codes
+	  is given also release them in the same edit.
+	plan
+	  Show what update would change, as diffs for text and SHA1s for
+	  images, without changing packageName.
 	images
-	  Update packageName images using the files in images.
+	  Update packageName images using the files in images.  The files are
+	  checked against the Play image specs (count, file size, pixel size,
+	  aspect ratio, transparency and format) before any upload.  With
+	  -check they are only checked, offline, for the locales pulled into
+	  listings.
 	text
 	  Update packageName text using the files in words.
+	pull
+	  Download packageName listings into listings and images into images.
+	  Images that are already the same are not downloaded.  Local images
+	  that are not live are listed, with -prune they are deleted.
+	convert-sub
+	  Print the 'sub' file as YAML.  It needs no packageName.
+	validate
+	  Check, offline, the text update would make for length limits, empty
+	  fields, disallowed characters and untranslated text.  It translates
+	  the default language listing pulled into listings.  The update, plan
+	  and text commands make the same checks before changing anything.
+	release
+	  Upload the -bundle file, an Android App Bundle or APK, and release
+	  it, along with any -version-codes, to -track.  The -notes file, in
+	  the default language, is translated using the files in words for
+	  each listing.  With only -notes the current -track release notes are
+	  changed.
+	rollout packageName fraction
+	  Increase the staged rollout of the inProgress -track release to
+	  fraction, between 0 and 1, of users.
+	halt
+	  Halt the inProgress -track release.
+	resume
+	  Resume the halted -track release.
+	complete
+	  Roll the inProgress, or halted, -track release out to all users.
+	promote packageName fromTrack toTrack
+	  Copy the fromTrack release, its staged rollout if it has one, to
+	  toTrack.  With -fraction it is a staged rollout.
+	edit
+	  Make an edit, that is left open, and print its ID.  Give the ID to
+	  the images, text, update, plan, release, details and apply commands
+	  with -edit to make their changes in it.  Edits expire after about an hour.
+	pending
+	  List the changes in the -edit edit compared to the live app.
+	commit
+	  Validate and commit the -edit edit.
+	discard
+	  Delete the -edit edit and its changes.
+	details set [-email address] [-website url] [-phone number]
+	            [-default-lang lang] packageName
+	  Change the packageName contact details and default language.  Only
+	  the details given are changed.  The new default language must
+	  already have a listing, it is checked before anything is changed.
+	apply manifest.yaml
+	  Make the app match the manifest file.  It declares the package,
+	  default language, contact email, website and phone, the locales to
+	  maintain, where the text and images come from and a release.  Missing
+	  locale listings are made.  It is one edit, like update.
 	  
   If one or more lang arguments are provided only check those.
+  With -format fastlane the text and images come from, and are pulled
+  into, the fastlane supply metadata directory instead of words, images
+  and listings.  The fastlane text is used as is, it is not translated.
+  With -dry-run the images, text, update, release, details and apply
+  commands only show what they would change.  Otherwise they validate the
+  edit before committing it.  With -not-sent-for-review every command that
+  commits an edit commits it without sending the changes for review, so
+  they can be sent from the Play Console.  Apps with managed publishing
+  need this.
+  With -timeout a command that takes longer is cancelled.  A command that
+  fails, is cancelled or is interrupted deletes the edit it made.  Edits
+  given with -edit are left open.
+  API calls that hit a rate limit or a server error are retried, with
+  backoff, up to -retry-attempts times and for up to -retry-elapsed.
+  No more than -rate calls are started a second.  With -parallel the
+  info, images, text and update commands work on that many locales at
+  once, the output is still in locale order.
+  With -output json or yaml the info, images, text, update, plan,
+  release, rollout, halt, resume, complete, promote, details and apply
+  commands print their result in that format, for scripts, instead of as
+  text.  Progress messages go to stderr.
 
 `
 )
@@ -54,53 +147,374 @@ func main() {
 		"images", defaultImagesDir,
 		"Images directory.",
 	)
+	listingsDir := flag.String(
+		"listings", defaultListingsDir,
+		"Pulled listings directory.",
+	)
+	format := flag.String(
+		"format", apt.FormatWords,
+		"Text and images format, words or fastlane.",
+	)
+	metadataDir := flag.String(
+		"metadata", defaultMetadataDir,
+		"The fastlane supply metadata directory for -format fastlane.",
+	)
 	updateSubFile := flag.String(
 		"sub", defaultUpdateSub,
 		"Default update substitutions.",
 	)
+	check := flag.Bool(
+		"check", false,
+		"Only check the image files, offline, for the images command.",
+	)
+	prune := flag.Bool(
+		"prune", false,
+		"Delete local images that are not live, for the pull command.",
+	)
+	dryRun := flag.Bool(
+		"dry-run", false,
+		"Show changes and discard the edit instead of committing it.",
+	)
+	editId := flag.String(
+		"edit", "",
+		"Open edit ID, from the edit command, to make the changes in.",
+	)
+	notSentForReview := flag.Bool(
+		"not-sent-for-review", false,
+		"Commit without sending the changes for review, for managed publishing.",
+	)
+	bundle := flag.String(
+		"bundle", "",
+		"Android App Bundle (.aab) or APK (.apk) to release.",
+	)
+	versionCodes := flag.String(
+		"version-codes", "",
+		"Comma separated, already uploaded, version codes to release.",
+	)
+	track := flag.String(
+		"track", "",
+		"Release track (internal, alpha, beta, production or custom).\n"+
+			"The default is "+defaultTrack+" for release and "+defaultRollout+
+			" for rollout commands.",
+	)
+	releaseName := flag.String(
+		"release-name", "",
+		"Release name.",
+	)
+	status := flag.String(
+		"status", defaultStatus,
+		"Release status (draft, inProgress, halted or completed).",
+	)
+	fraction := flag.Float64(
+		"fraction", 0,
+		"Staged rollout user fraction for inProgress and halted releases.",
+	)
+	notesFile := flag.String(
+		"notes", "",
+		"Release notes (what's new) file in the default language.",
+	)
+	timeout := flag.Duration(
+		"timeout", 0,
+		"Cancel the command, deleting its edit, after this long (0 is no limit).",
+	)
+	retryAttempts := flag.Int(
+		"retry-attempts", apt.DefaultRetryOptions.MaxAttempts,
+		"Most attempts for an API call that hits a rate limit or server error (1 is no retries).",
+	)
+	retryElapsed := flag.Duration(
+		"retry-elapsed", apt.DefaultRetryOptions.MaxElapsed,
+		"Most time for an API call and its retries (0 is no limit).",
+	)
+	rate := flag.Float64(
+		"rate", apt.DefaultRetryOptions.MaxRate,
+		"Most API calls started per second (0 is no limit).",
+	)
+	parallel := flag.Int(
+		"parallel", 1,
+		"How many locales to update at once.",
+	)
+	output := flag.String(
+		"output", apt.OutputText,
+		"Result output format, text, json or yaml.",
+	)
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, USAGE)
 		flag.PrintDefaults()
 	}
 	flag.Parse()
-	if err := isFile(*credentialsJson); err != nil {
+	// Offline commands don't need credentials.
+	offline := flag.Arg(0) == "validate" || flag.Arg(0) == "convert-sub" ||
+		(flag.Arg(0) == "images" && *check)
+	if err := isFile(*credentialsJson); err != nil && !offline {
 		fatal_usage(fmt.Errorf("credentialsJson got %v", err))
 	}
-	if flag.NArg() < 2 {
+	if flag.Arg(0) == "convert-sub" {
+		if err := apt.ConvertSubstitutions(os.Stdout, *updateSubFile); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	args := flag.Args()
+	var details *ap.AppDetails
+	if flag.Arg(0) == "details" {
+		details, args = parseDetailsSet(args)
+	}
+	if len(args) < 2 {
 		fatal_usage(fmt.Errorf("missing arguments"))
 	}
-	packageName := flag.Arg(1)
-	langs := flag.Args()[2:]
+	packageName := args[1]
+	langs := args[2:]
+	// Where the text and images come from.
+	textDir, imageDir, pullDir := *wordsDir, *imagesDir, *listingsDir
+	switch *format {
+	case apt.FormatWords:
+	case apt.FormatFastlane:
+		// Fastlane has its own text and images.
+		textDir, imageDir, pullDir = *metadataDir, *metadataDir, *metadataDir
+	default:
+		fatal_usage(fmt.Errorf("bad format %s", *format))
+	}
+	switch *output {
+	case apt.OutputText, apt.OutputJson, apt.OutputYaml:
+	default:
+		fatal_usage(fmt.Errorf("bad output %s", *output))
+	}
+	switch flag.Arg(0) {
+	case "images", "text", "update", "plan", "release", "details", "apply":
+	case "pending", "commit", "discard":
+		if *editId == "" {
+			fatal_usage(fmt.Errorf("%s needs -edit", flag.Arg(0)))
+		}
+	default:
+		if *editId != "" {
+			fatal_usage(fmt.Errorf("%s can't use -edit", flag.Arg(0)))
+		}
+	}
+	codes, err := parseVersionCodes(*versionCodes)
+	if err != nil {
+		fatal_usage(err)
+	}
+	trackName := *track
+	if trackName == "" {
+		trackName = defaultTrack
+	}
+	rolloutTrack := *track
+	if rolloutTrack == "" {
+		rolloutTrack = defaultRollout
+	}
+	var release *apt.Release
+	if *bundle != "" || len(codes) != 0 || *notesFile != "" {
+		if *bundle != "" {
+			if err = isFile(*bundle); err != nil {
+				fatal_usage(err)
+			}
+		}
+		notes := ""
+		if *notesFile != "" {
+			if err = isDir(*wordsDir); err != nil {
+				fatal_usage(err)
+			}
+			bytes, err := ioutil.ReadFile(*notesFile)
+			if err != nil {
+				fatal_usage(fmt.Errorf("notes got %v", err))
+			}
+			notes = strings.TrimSpace(string(bytes))
+		}
+		release = &apt.Release{
+			File:         *bundle,
+			VersionCodes: codes,
+			Track:        trackName,
+			Name:         *releaseName,
+			Status:       *status,
+			UserFraction: *fraction,
+			Notes:        notes,
+		}
+	}
+	// An interrupt cancels the command so its edit gets deleted.
+	ctx, stop := signal.NotifyContext(
+		context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	var pub apt.Publisher
+	if !offline {
+		// The client refreshes its token with the context it is made with,
+		// so it mustn't be cancelled before the edit is cleaned up.
+		pub, err = apt.GetPublisher(context.Background(), *credentialsJson)
+		if err != nil {
+			fatal(fmt.Errorf("connecting to %s got %v", *credentialsJson, err))
+		}
+		retry := apt.DefaultRetryOptions
+		retry.MaxAttempts = *retryAttempts
+		retry.MaxElapsed = *retryElapsed
+		retry.MaxRate = *rate
+		pub = apt.NewRetryPublisher(pub, retry)
+	}
 
-	// Run command.
-	var err error
+	// Run command.  Commands with a result set it to be output.
+	var result interface{}
 	switch flag.Arg(0) {
 	case "info":
-		err = apt.PackageInfo(os.Stdout, *credentialsJson, packageName, langs)
+		result, err = apt.PackageInfo(ctx, pub, packageName, langs, *parallel)
 	case "images":
-		if err = isDir(*imagesDir); err != nil {
+		if err = isDir(imageDir); err != nil {
 			fatal_usage(err)
 		}
-		err = apt.PackageUpdateImages(
-			*credentialsJson, packageName, *imagesDir, langs)
+		if *check {
+			err = apt.PackageCheckImages(*listingsDir, apt.UpdateOptions{
+				ImagesDir:   *imagesDir,
+				Langs:       langs,
+				Format:      *format,
+				MetadataDir: *metadataDir,
+			})
+			break
+		}
+		result, err = apt.PackageUpdate(ctx, pub, packageName, apt.UpdateOptions{
+			ImagesDir:        *imagesDir,
+			Langs:            langs,
+			DoImages:         true,
+			Format:           *format,
+			MetadataDir:      *metadataDir,
+			DryRun:           *dryRun,
+			Parallel:         *parallel,
+			NotSentForReview: *notSentForReview,
+			EditId:           *editId,
+		})
 	case "text":
-		if err = isDir(*wordsDir); err != nil {
+		if err = isDir(textDir); err != nil {
+			fatal_usage(err)
+		}
+		result, err = apt.PackageUpdate(ctx, pub, packageName, apt.UpdateOptions{
+			SubFile:          *updateSubFile,
+			WordsDir:         *wordsDir,
+			Langs:            langs,
+			DoText:           true,
+			Format:           *format,
+			MetadataDir:      *metadataDir,
+			DryRun:           *dryRun,
+			Parallel:         *parallel,
+			NotSentForReview: *notSentForReview,
+			EditId:           *editId,
+		})
+	case "update", "plan":
+		if err = isDir(textDir); err != nil {
 			fatal_usage(err)
 		}
-		err = apt.PackageUpdateText(*credentialsJson, packageName, *updateSubFile, *wordsDir, langs)
-	case "update":
-		if err = isDir(*wordsDir); err != nil {
+		if err = isDir(imageDir); err != nil {
 			fatal_usage(err)
 		}
-		if err = isDir(*imagesDir); err != nil {
+		result, err = apt.PackageUpdate(ctx, pub, packageName, apt.UpdateOptions{
+			SubFile:          *updateSubFile,
+			WordsDir:         *wordsDir,
+			ImagesDir:        *imagesDir,
+			Langs:            langs,
+			DoText:           true,
+			DoImages:         true,
+			Format:           *format,
+			MetadataDir:      *metadataDir,
+			DryRun:           *dryRun || flag.Arg(0) == "plan",
+			Release:          release,
+			Parallel:         *parallel,
+			NotSentForReview: *notSentForReview,
+			EditId:           *editId,
+		})
+	case "validate":
+		if err = isDir(textDir); err != nil {
 			fatal_usage(err)
 		}
-		err = apt.PackageUpdate(
-			*credentialsJson, packageName, *updateSubFile, *wordsDir, *imagesDir, langs, true, true)
+		err = apt.PackageValidate(*listingsDir, apt.UpdateOptions{
+			SubFile:     *updateSubFile,
+			WordsDir:    *wordsDir,
+			Langs:       langs,
+			Format:      *format,
+			MetadataDir: *metadataDir,
+		})
+	case "pull":
+		err = apt.PackagePull(ctx, pub, os.Stderr, packageName, *format,
+			pullDir, *imagesDir, langs, *prune)
+	case "release":
+		if release == nil {
+			fatal_usage(fmt.Errorf("release needs -bundle, -version-codes or -notes"))
+		}
+		result, err = apt.PackageUpdate(ctx, pub, packageName, apt.UpdateOptions{
+			WordsDir:         *wordsDir,
+			Langs:            langs,
+			DryRun:           *dryRun,
+			Release:          release,
+			NotSentForReview: *notSentForReview,
+			EditId:           *editId,
+		})
+	case "rollout":
+		if flag.NArg() != 3 {
+			fatal_usage(fmt.Errorf("rollout needs packageName fraction"))
+		}
+		rolloutFraction, perr := strconv.ParseFloat(flag.Arg(2), 64)
+		if perr != nil {
+			fatal_usage(fmt.Errorf("bad fraction %s", flag.Arg(2)))
+		}
+		result, err = apt.PackageRollout(
+			ctx, pub, os.Stderr, packageName, rolloutTrack, rolloutFraction,
+			*dryRun, *notSentForReview)
+	case "halt":
+		result, err = apt.PackageHalt(
+			ctx, pub, os.Stderr, packageName, rolloutTrack, *dryRun, *notSentForReview)
+	case "resume":
+		result, err = apt.PackageResume(
+			ctx, pub, os.Stderr, packageName, rolloutTrack, *dryRun, *notSentForReview)
+	case "complete":
+		result, err = apt.PackageComplete(
+			ctx, pub, os.Stderr, packageName, rolloutTrack, *dryRun, *notSentForReview)
+	case "promote":
+		if flag.NArg() != 4 {
+			fatal_usage(fmt.Errorf("promote needs packageName fromTrack toTrack"))
+		}
+		result, err = apt.PackagePromote(
+			ctx, pub, os.Stderr, packageName, flag.Arg(2), flag.Arg(3),
+			*fraction, *dryRun, *notSentForReview)
+	case "details":
+		if len(langs) != 0 {
+			fatal_usage(fmt.Errorf("details set needs only a packageName"))
+		}
+		result, err = apt.PackageSetDetails(ctx, pub, packageName, *details,
+			apt.UpdateOptions{
+				DryRun:           *dryRun,
+				NotSentForReview: *notSentForReview,
+				EditId:           *editId,
+			})
+	case "apply":
+		if len(langs) != 0 {
+			fatal_usage(fmt.Errorf("apply needs only a manifest file"))
+		}
+		result, err = apt.PackageApply(ctx, pub, flag.Arg(1), apt.UpdateOptions{
+			DryRun:           *dryRun,
+			Parallel:         *parallel,
+			NotSentForReview: *notSentForReview,
+			EditId:           *editId,
+		})
+	case "edit":
+		result, err = apt.PackageEdit(ctx, pub, packageName)
+	case "pending":
+		result, err = apt.PackagePending(
+			ctx, pub, packageName, *editId, langs, *parallel)
+	case "commit":
+		err = apt.PackageCommit(
+			ctx, pub, packageName, *editId, *notSentForReview)
+	case "discard":
+		err = apt.PackageDiscard(ctx, pub, packageName, *editId)
+	default:
+		fatal_usage(fmt.Errorf("unknown command %s", flag.Arg(0)))
 	}
 	if err != nil {
 		fatal(err)
 	}
+	if result != nil {
+		if err := apt.WriteOutput(os.Stdout, *output, result); err != nil {
+			fatal(err)
+		}
+	}
 }
 
 func fatal_usage(err error) {
@@ -114,6 +528,59 @@ func fatal(err error) {
 	os.Exit(2)
 }
 
+// parseDetailsSet parses the "details set" command, which has its own
+// flags, from the command arguments.  It returns the details to change and
+// the arguments without the flags.
+func parseDetailsSet(args []string) (*ap.AppDetails, []string) {
+	if len(args) < 2 || args[1] != "set" {
+		fatal_usage(fmt.Errorf("details needs set"))
+	}
+	set := flag.NewFlagSet("details set", flag.ExitOnError)
+	email := set.String("email", "", "Contact email address.")
+	website := set.String("website", "", "Contact website URL.")
+	phone := set.String("phone", "", "Contact phone number.")
+	defaultLang := set.String(
+		"default-lang", "",
+		"BCP-47 default language, it must already have a listing.",
+	)
+	set.Usage = func() {
+		fmt.Fprintf(os.Stderr,
+			"Usage:\n\tandroidpkg [flags..] details set [flags..] packageName\n\n")
+		set.PrintDefaults()
+	}
+	set.Parse(args[2:])
+	if *email == "" && *website == "" && *phone == "" && *defaultLang == "" {
+		fmt.Fprintf(os.Stderr, "error: %v\n", fmt.Errorf(
+			"details set needs -email, -website, -phone or -default-lang"))
+		set.Usage()
+		os.Exit(2)
+	}
+	details := &ap.AppDetails{
+		ContactEmail:    *email,
+		ContactWebsite:  *website,
+		ContactPhone:    *phone,
+		DefaultLanguage: *defaultLang,
+	}
+	return details, append([]string{args[0]}, set.Args()...)
+}
+
+// parseVersionCodes parses a comma separated list of version codes.
+func parseVersionCodes(list string) ([]int64, error) {
+	var codes []int64
+	for _, tok := range strings.Split(list, ",") {
+		tok = strings.TrimSpace(tok)
+		if tok == "" {
+			continue
+		}
+		code, err := strconv.ParseInt(tok, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad version code '%s'", tok)
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
 func isDir(dir string) error {
 	fileInfo, err := os.Stat(dir)
 	if err != nil {
@@ -6,7 +6,9 @@ go 1.17
 
 require (
 	github.com/napcatstudio/translate/v2 v2.0.2
+	github.com/rivo/uniseg v0.2.0
 	google.golang.org/api v0.82.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (